@@ -0,0 +1,151 @@
+package traefik_cache
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ghnexpress/traefik-cache/constants"
+	"github.com/ghnexpress/traefik-cache/log"
+)
+
+const defaultAccessLogSampleRate = 100 // percent
+
+// decision records how the cache handled a request. It is carried by the request context,
+// updates made after it was logged, e.g. by a background revalidation, are ignored.
+type decision struct {
+	mu     sync.Mutex
+	logged bool
+	access bool
+
+	key        string
+	lookup     string
+	status     constants.CacheStatus
+	reasons    []string
+	stored     bool
+	ttl        time.Duration
+	grace      time.Duration
+	size       int
+	backendErr error
+
+	lookupDuration   time.Duration
+	upstreamDuration time.Duration
+	storeDuration    time.Duration
+}
+
+type decisionKey struct{}
+
+func decisionOf(req *http.Request) *decision {
+	d, _ := req.Context().Value(decisionKey{}).(*decision)
+	return d
+}
+
+func withDecision(ctx context.Context, d *decision) context.Context {
+	if d == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, decisionKey{}, d)
+}
+
+// update applies fn to the decision, unless nil or already logged.
+func (d *decision) update(fn func(d *decision)) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.logged {
+		fn(d)
+	}
+}
+
+// addReason records a reason not to store the response, once.
+func (d *decision) addReason(reason string) {
+	d.update(func(d *decision) {
+		for _, r := range d.reasons {
+			if r == reason {
+				return
+			}
+		}
+
+		d.reasons = append(d.reasons, reason)
+	})
+}
+
+// cacheStatus returns the status set by setCacheStatus, empty when none was set.
+func (d *decision) cacheStatus() constants.CacheStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.status
+}
+
+// startDecision attaches a decision to the request, access telling whether it is sampled
+// for the access log.
+func (c *Cache) startDecision(req *http.Request) (*http.Request, *decision) {
+	d := &decision{access: c.config.AccessLog.Enable && c.accessLogSampled()}
+
+	return req.WithContext(withDecision(req.Context(), d)), d
+}
+
+func (c *Cache) accessLogSampled() bool {
+	rate := c.config.AccessLog.SampleRate
+	if rate <= 0 {
+		rate = defaultAccessLogSampleRate
+	}
+
+	return rate >= 100 || rand.Intn(100) < rate
+}
+
+// logDecision writes the decision as the access log line of the request, or at debug level
+// when the request was not selected for the access log.
+func (c *Cache) logDecision(req *http.Request, requestID string, d *decision, duration time.Duration) {
+	d.mu.Lock()
+	d.logged = true
+	d.mu.Unlock()
+
+	if !d.access && !c.log.Enabled(log.DebugLevel) {
+		return
+	}
+
+	fields := log.Fields{
+		"requestId":  requestID,
+		"method":     req.Method,
+		"uri":        req.URL.RequestURI(),
+		"key":        d.key,
+		"lookup":     d.lookup,
+		"status":     d.status,
+		"stored":     d.stored,
+		"durationMs": milliseconds(duration),
+		"lookupMs":   milliseconds(d.lookupDuration),
+		"upstreamMs": milliseconds(d.upstreamDuration),
+	}
+	if len(d.reasons) > 0 {
+		fields["reasons"] = d.reasons
+	}
+	if d.stored {
+		fields["ttl"] = int64(d.ttl.Seconds())
+		fields["grace"] = int64(d.grace.Seconds())
+		fields["size"] = d.size
+		fields["storeMs"] = milliseconds(d.storeDuration)
+	}
+	if d.backendErr != nil {
+		fields["backendError"] = d.backendErr
+	}
+
+	if d.access && !(c.config.AccessLog.OnlyNonHits && d.status == constants.HitCacheStatus) {
+		c.log.Info("Cache decision", fields)
+		return
+	}
+
+	c.log.Debug("Request served", fields)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
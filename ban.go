@@ -0,0 +1,358 @@
+package traefik_cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghnexpress/traefik-cache/constants"
+	"github.com/ghnexpress/traefik-cache/model"
+	"github.com/ghnexpress/traefik-cache/utils"
+)
+
+const defaultBanRetention = 24 * 60 * 60 // second
+
+const (
+	// banRefreshInterval bounds how long the bans registered by other instances take to
+	// apply here, and how often the ban counters of a host are read on lookups.
+	banRefreshInterval = time.Second
+	// banMissingGrace is how long a ban number reserved by another instance but not yet
+	// written is looked for again before being given up.
+	banMissingGrace = 10 * time.Second
+	maxBanHosts     = 1024
+)
+
+var (
+	banRegexps      = make(map[string]*regexp.Regexp)
+	banRegexpsMutex = sync.Mutex{}
+)
+
+// Entries cannot be enumerated in memcached, so invalidations are recorded as bans, checked
+// against the entries found on lookup. Each ban is stored under its own key, numbered by a
+// per-host counter incremented atomically in the backend: concurrent purges on several
+// instances or routers cannot drop each other's bans. The counters restart every retention
+// period, the epoch, so that numbers and keys never outlive the bans they refer to.
+
+func banEpoch(now time.Time, retention time.Duration) int64 {
+	return now.Unix() / int64(retention/time.Second)
+}
+
+func banCounterKey(host string, epoch int64) string {
+	return utils.GetMD5Hash([]byte(fmt.Sprintf("bans-counter|%s|%d", host, epoch)))
+}
+
+func banKey(host string, epoch int64, n uint64) string {
+	return utils.GetMD5Hash([]byte(fmt.Sprintf("ban|%s|%d|%d", host, epoch, n)))
+}
+
+// bansEnabled tells whether bans may be added for the hosts. The bans of unsafe requests
+// are stored apart, see setUnsafeBans.
+func (c *Cache) bansEnabled() bool {
+	return c.config.Purge.Enable || c.config.Invalidation.Enable
+}
+
+func (c *Cache) banRetention() time.Duration {
+	if c.config.Purge.Retention > 0 {
+		return time.Duration(c.config.Purge.Retention) * time.Second
+	}
+
+	return defaultBanRetention * time.Second
+}
+
+// addBans stores the bans of the host. They are registered once it returns nil.
+func (c *Cache) addBans(host string, bans ...model.Ban) error {
+	now := time.Now()
+	retention := c.banRetention()
+	epoch := banEpoch(now, retention)
+
+	last, err := c.cacheRepo.Increment(banCounterKey(host, epoch), uint64(len(bans)), time.Unix((epoch+2)*int64(retention/time.Second), 0))
+	if err != nil {
+		return err
+	}
+
+	hb := c.bans.host(host)
+	for i, ban := range bans {
+		body, err := json.Marshal(ban)
+		if err != nil {
+			return fmt.Errorf("Marshal ban error: %v", err)
+		}
+
+		key := banKey(host, epoch, last-uint64(len(bans)-1-i))
+		if err := c.cacheRepo.SetExpires(key, now.Add(retention), model.Cache{Body: body}); err != nil {
+			return err
+		}
+
+		// Applied here at once, the other instances find it on their next refresh.
+		hb.mu.Lock()
+		hb.bans[key] = ban
+		hb.mu.Unlock()
+	}
+
+	return nil
+}
+
+// banned reports whether a ban registered after the entry was stored matches it.
+func (c *Cache) banned(req *http.Request, requestID string, value *model.Cache) bool {
+	if c.config.InvalidateOnUnsafe.Enable {
+		banned, err := c.unsafeBanned(req.Host, value)
+		if err != nil {
+			c.log.Alert(requestID, err)
+		} else if banned {
+			return true
+		}
+	}
+
+	if !c.bansEnabled() {
+		return false
+	}
+
+	hb := c.bans.host(req.Host)
+	if err := c.refreshBans(req.Host, hb); err != nil {
+		c.log.Alert(requestID, err)
+	}
+
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+
+	for _, ban := range hb.bans {
+		if ban.CreatedAt > value.StoredAt && matchBan(ban, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// banCache keeps the bans of the hosts in process, so that lookups only read the ban
+// counters of the host, and at most once per banRefreshInterval.
+type banCache struct {
+	mu    sync.Mutex
+	hosts map[string]*hostBans
+}
+
+type hostBans struct {
+	mu   sync.Mutex
+	bans map[string]model.Ban
+	// last is the highest ban number read per epoch.
+	last map[int64]uint64
+	// missing maps the keys of the reserved bans not found yet to when they were first
+	// looked for.
+	missing map[string]time.Time
+	checked time.Time
+	// refreshing is closed when the refresh in progress is done.
+	refreshing chan struct{}
+}
+
+func newBanCache() *banCache {
+	return &banCache{hosts: make(map[string]*hostBans)}
+}
+
+func (b *banCache) host(host string) *hostBans {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	hb, ok := b.hosts[host]
+	if !ok {
+		// Hosts are not bounded by the configuration: start over rather than grow forever.
+		if len(b.hosts) >= maxBanHosts {
+			b.hosts = make(map[string]*hostBans)
+		}
+
+		hb = &hostBans{bans: make(map[string]model.Ban), last: make(map[int64]uint64), missing: make(map[string]time.Time)}
+		b.hosts[host] = hb
+	}
+
+	return hb
+}
+
+// refreshBans reads the bans added for the host since the last refresh, once per
+// banRefreshInterval. Lookups during a refresh use the bans already known, except before
+// the first one completed.
+func (c *Cache) refreshBans(host string, hb *hostBans) error {
+	hb.mu.Lock()
+	if time.Since(hb.checked) < banRefreshInterval {
+		hb.mu.Unlock()
+		return nil
+	}
+
+	if wait := hb.refreshing; wait != nil {
+		loaded := !hb.checked.IsZero()
+		hb.mu.Unlock()
+
+		if !loaded {
+			<-wait
+		}
+
+		return nil
+	}
+
+	done := make(chan struct{})
+	hb.refreshing = done
+	last := make(map[int64]uint64, len(hb.last))
+	for epoch, n := range hb.last {
+		last[epoch] = n
+	}
+	hb.mu.Unlock()
+
+	found, missing, err := c.readBans(host, hb, last)
+
+	now := time.Now()
+	oldest := now.Add(-c.banRetention()).UnixNano()
+
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+
+	for key, ban := range found {
+		hb.bans[key] = ban
+		delete(hb.missing, key)
+	}
+
+	for _, key := range missing {
+		if _, ok := hb.missing[key]; !ok {
+			hb.missing[key] = now
+		}
+	}
+
+	for key, since := range hb.missing {
+		if now.Sub(since) > banMissingGrace {
+			delete(hb.missing, key)
+		}
+	}
+
+	for key, ban := range hb.bans {
+		if ban.CreatedAt < oldest {
+			delete(hb.bans, key)
+		}
+	}
+
+	// On error, the numbers not read yet are read again on the next refresh.
+	if err == nil {
+		hb.last = last
+	}
+	hb.checked = now
+	hb.refreshing = nil
+	close(done)
+
+	return err
+}
+
+// readBans gets the bans numbered after last in the current and previous epochs, updating
+// last, and retries the missing ones. It returns the bans found by key, and the keys of
+// the reserved numbers not written yet.
+func (c *Cache) readBans(host string, hb *hostBans, last map[int64]uint64) (map[string]model.Ban, []string, error) {
+	retention := c.banRetention()
+	epoch := banEpoch(time.Now(), retention)
+	found := make(map[string]model.Ban)
+
+	hb.mu.Lock()
+	keys := make([]string, 0, len(hb.missing))
+	for key := range hb.missing {
+		keys = append(keys, key)
+	}
+	hb.mu.Unlock()
+
+	for e := epoch - 1; e <= epoch; e++ {
+		n, err := c.cacheRepo.Increment(banCounterKey(host, e), 0, time.Unix((e+2)*int64(retention/time.Second), 0))
+		if err != nil {
+			return found, nil, err
+		}
+
+		hb.mu.Lock()
+		for i := last[e] + 1; i <= n; i++ {
+			key := banKey(host, e, i)
+			if _, ok := hb.bans[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		hb.mu.Unlock()
+
+		last[e] = n
+	}
+
+	for e := range last {
+		if e < epoch-1 {
+			delete(last, e)
+		}
+	}
+
+	var missing []string
+	for _, key := range keys {
+		stored, err := c.cacheRepo.Get(key)
+		if err != nil {
+			return found, missing, err
+		}
+
+		if stored == nil {
+			missing = append(missing, key)
+			continue
+		}
+
+		var ban model.Ban
+		if err := json.Unmarshal(stored.Body, &ban); err != nil {
+			return found, missing, fmt.Errorf("Unmarshal ban error: %v", err)
+		}
+
+		found[key] = ban
+	}
+
+	return found, missing, nil
+}
+
+func matchBan(ban model.Ban, value *model.Cache) bool {
+	switch constants.BanType(ban.Type) {
+	case constants.URLBanType:
+		return value.URL == ban.Value
+	case constants.PrefixBanType:
+		return strings.HasPrefix(value.URL, ban.Value)
+	case constants.TagBanType:
+		return containsName(value.Tags, ban.Value)
+	case constants.RegexBanType:
+		re, err := banRegexp(ban.Value)
+		return err == nil && re.MatchString(value.URL)
+	default:
+		return false
+	}
+}
+
+// banRegexp compiles the pattern of a regex ban once per process.
+func banRegexp(pattern string) (*regexp.Regexp, error) {
+	banRegexpsMutex.Lock()
+	defer banRegexpsMutex.Unlock()
+
+	if re, ok := banRegexps[pattern]; ok {
+		return re, nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	banRegexps[pattern] = re
+
+	return re, nil
+}
+
+// surrogateKeys returns the tags of a response, from the space-separated Surrogate-Key
+// and the comma-separated Cache-Tag headers.
+func surrogateKeys(header http.Header) []string {
+	var tags []string
+
+	for _, v := range header.Values("Surrogate-Key") {
+		tags = append(tags, strings.Fields(v)...)
+	}
+
+	for _, v := range header.Values("Cache-Tag") {
+		for _, tag := range strings.Split(v, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	return tags
+}
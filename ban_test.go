@@ -0,0 +1,113 @@
+package traefik_cache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ghnexpress/traefik-cache/constants"
+	"github.com/ghnexpress/traefik-cache/model"
+	"github.com/ghnexpress/traefik-cache/repo"
+)
+
+// countingRepo counts the Get calls made to the backend.
+type countingRepo struct {
+	repo.Repository
+
+	mu   sync.Mutex
+	gets int
+}
+
+func (r *countingRepo) Get(key string) (*model.Cache, error) {
+	r.mu.Lock()
+	r.gets++
+	r.mu.Unlock()
+
+	return r.Repository.Get(key)
+}
+
+func (r *countingRepo) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.gets
+}
+
+func newBanTestCache(backend repo.Repository) *Cache {
+	c := &Cache{cacheRepo: backend, bans: newBanCache(), unsafeBans: newUnsafeBanCache()}
+	c.config.Purge.Enable = true
+
+	return c
+}
+
+func TestAddBansConcurrent(t *testing.T) {
+	backend := repo.NewMemoryRepo(model.MemoryConfig{})
+	// Two routers, or instances, sharing the backend.
+	routers := []*Cache{newBanTestCache(backend), newBanTestCache(backend)}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			ban := model.Ban{Type: string(constants.URLBanType), Value: fmt.Sprintf("/items/%d", i), CreatedAt: time.Now().UnixNano()}
+			if err := routers[i%2].addBans("example.com", ban); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	c := newBanTestCache(backend)
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	for i := 0; i < 100; i++ {
+		value := &model.Cache{URL: fmt.Sprintf("/items/%d", i), StoredAt: 1}
+		if !c.banned(req, "", value) {
+			t.Errorf("ban of %s lost", value.URL)
+		}
+	}
+}
+
+func TestBannedMemoized(t *testing.T) {
+	backend := &countingRepo{Repository: repo.NewMemoryRepo(model.MemoryConfig{})}
+	other := newBanTestCache(backend)
+	c := newBanTestCache(backend)
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	value := &model.Cache{URL: "/a", StoredAt: time.Now().UnixNano()}
+
+	if c.banned(req, "", value) {
+		t.Fatal("banned without bans")
+	}
+
+	gets := backend.count()
+	for i := 0; i < 100; i++ {
+		c.banned(req, "", value)
+	}
+	if backend.count() != gets {
+		t.Errorf("%d backend reads for 100 lookups within the refresh interval", backend.count()-gets)
+	}
+
+	// A ban added by another instance applies once the bans are refreshed.
+	if err := other.addBans("example.com", model.Ban{Type: string(constants.URLBanType), Value: "/a", CreatedAt: time.Now().UnixNano()}); err != nil {
+		t.Fatal(err)
+	}
+	if c.banned(req, "", value) {
+		t.Error("ban of another instance applied before the refresh interval")
+	}
+
+	time.Sleep(banRefreshInterval)
+
+	if !c.banned(req, "", value) {
+		t.Error("ban of another instance not applied after the refresh interval")
+	}
+
+	// Entries stored after the ban are not affected.
+	if c.banned(req, "", &model.Cache{URL: "/a", StoredAt: time.Now().UnixNano()}) {
+		t.Error("entry stored after the ban is banned")
+	}
+}
@@ -0,0 +1,144 @@
+package traefik_cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghnexpress/traefik-cache/constants"
+	"github.com/ghnexpress/traefik-cache/model"
+)
+
+// CACHE_IDENTIFIER names this cache in the RFC 9211 Cache-Status header.
+const CACHE_IDENTIFIER = "traefik-cache"
+
+// Forward reasons of RFC 9211 §2.2.
+const (
+	fwdMethod   = "method"
+	fwdURIMiss  = "uri-miss"
+	fwdVaryMiss = "vary-miss"
+	fwdMiss     = "miss"
+	fwdStale    = "stale"
+)
+
+// cacheStatus is how the cache handled a request. status is the legacy cache-status value,
+// the other fields the parameters of the RFC 9211 Cache-Status member.
+type cacheStatus struct {
+	status    constants.CacheStatus
+	fwd       string
+	fwdStatus int
+	value     *model.Cache
+	stored    bool
+	collapsed bool
+	detail    string
+}
+
+func hitStatus(value *model.Cache) cacheStatus {
+	return cacheStatus{status: constants.HitCacheStatus, value: value}
+}
+
+func staleStatus(value *model.Cache) cacheStatus {
+	return cacheStatus{status: constants.StaleCacheStatus, value: value}
+}
+
+// setCacheStatus records the status of the request and writes it to the response header:
+// the legacy value replacing the header when cacheStatus.legacy is set, or else a member
+// appended to the Cache-Status members of the upstream.
+func (c *Cache) setCacheStatus(header http.Header, req *http.Request, key string, st cacheStatus) {
+	decisionOf(req).update(func(d *decision) { d.status = st.status })
+
+	if c.config.CacheStatus.Legacy {
+		header.Set(CACHE_HEADER, string(st.status))
+		return
+	}
+
+	header.Add(CACHE_HEADER, c.cacheStatusMember(key, st))
+}
+
+func (c *Cache) cacheStatusMember(key string, st cacheStatus) string {
+	var b strings.Builder
+	b.WriteString(CACHE_IDENTIFIER)
+
+	if st.fwd == "" {
+		b.WriteString("; hit")
+	} else {
+		b.WriteString("; fwd=" + st.fwd)
+		if st.fwdStatus != 0 {
+			b.WriteString("; fwd-status=" + strconv.Itoa(st.fwdStatus))
+		}
+	}
+
+	// Negative once stale.
+	if st.value != nil && st.value.Expires != 0 {
+		b.WriteString("; ttl=" + strconv.FormatInt(st.value.Expires-time.Now().Unix(), 10))
+	}
+
+	if st.stored {
+		b.WriteString("; stored")
+	}
+
+	if st.collapsed {
+		b.WriteString("; collapsed")
+	}
+
+	if key != "" && c.config.Env == DEV_ENV {
+		b.WriteString("; key=" + strconv.Quote(key))
+	}
+
+	if st.detail != "" {
+		b.WriteString("; detail=" + strconv.Quote(st.detail))
+	}
+
+	return b.String()
+}
+
+// passThrough calls the upstream without caching, the status being written once the
+// upstream headers are known.
+func (c *Cache) passThrough(rw http.ResponseWriter, req *http.Request, key string, st cacheStatus) {
+	r := newResponseWriter(rw)
+	r.truncated = true
+	r.beforeWriteHeader = func(int) {
+		c.setCacheStatus(rw.Header(), req, key, st)
+	}
+
+	c.upstream(r, req)
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+}
+
+// storable tells whether a response of status and header sent to req is going to be stored,
+// the body not being known yet. Without Content-Length, the body may still turn out larger
+// than maxBodySize: it is not claimed.
+func (c *Cache) storable(req *http.Request, status int, header http.Header) bool {
+	if req.Method == http.MethodHead || status == http.StatusNotModified || status == http.StatusPartialContent {
+		return false
+	}
+
+	if length := contentLength(header); length < 0 || length > c.maxBodySize() {
+		return false
+	}
+
+	if !decodable(header) {
+		return false
+	}
+
+	if containsName(varyNames(header), "*") {
+		return false
+	}
+
+	_, ok := c.expiration(req, status, header)
+
+	return ok
+}
+
+// contentLength returns the Content-Length of header, -1 when unknown.
+func contentLength(header http.Header) int {
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return -1
+	}
+
+	return length
+}
@@ -0,0 +1,64 @@
+package traefik_cache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ghnexpress/traefik-cache/model"
+)
+
+const defaultCoalesceMaxWait = 10 // second
+
+// call is one upstream request shared by every concurrent miss on the same key.
+type call struct {
+	done  chan struct{}
+	value *model.Cache
+}
+
+// wait blocks until the leader finished or maxWait elapsed. A nil result means the
+// waiter has to call the upstream itself.
+func (cl *call) wait(maxWait time.Duration) *model.Cache {
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+
+	select {
+	case <-cl.done:
+		return cl.value
+	case <-timer.C:
+		return nil
+	}
+}
+
+type coalescer struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+func newCoalescer() *coalescer {
+	return &coalescer{calls: make(map[string]*call)}
+}
+
+// join returns the in-flight call for key, and whether the caller is its leader.
+func (g *coalescer) join(key string) (*call, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if cl, ok := g.calls[key]; ok {
+		return cl, false
+	}
+
+	cl := &call{done: make(chan struct{})}
+	g.calls[key] = cl
+
+	return cl, true
+}
+
+// finish publishes the value captured by the leader, nil when the response was not cacheable.
+func (g *coalescer) finish(key string, cl *call, value *model.Cache) {
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+
+	cl.value = value
+	close(cl.done)
+}
@@ -0,0 +1,305 @@
+package traefik_cache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ghnexpress/traefik-cache/model"
+	"github.com/ghnexpress/traefik-cache/repo"
+)
+
+// newTestCache returns a middleware in front of next, storing in its own memory backend.
+func newTestCache(t *testing.T, next http.Handler, configure func(*model.Config)) *Cache {
+	t.Helper()
+
+	config := CreateConfig()
+	config.Storage.Type = "memory"
+	config.Log.Level = "error"
+	if configure != nil {
+		configure(config)
+	}
+
+	h, err := New(context.Background(), next, config, t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := h.(*Cache)
+	// Backends are shared by configuration: keep every test apart.
+	c.cacheRepo = repo.NewMemoryRepo(model.MemoryConfig{MaxSize: 16})
+
+	return c
+}
+
+// countingHandler counts the upstream calls, blocking the first one until release is closed
+// when release is not nil.
+type countingHandler struct {
+	calls   int32
+	release chan struct{}
+	serve   func(n int32, rw http.ResponseWriter, req *http.Request)
+}
+
+func (h *countingHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	n := atomic.AddInt32(&h.calls, 1)
+	if n == 1 && h.release != nil {
+		<-h.release
+	}
+
+	h.serve(n, rw, req)
+}
+
+func (h *countingHandler) count() int32 {
+	return atomic.LoadInt32(&h.calls)
+}
+
+func get(c *Cache, url string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, req)
+
+	return rec
+}
+
+// getConcurrently sends n requests at once, the first one leading, and returns the responses.
+func getConcurrently(c *Cache, n int, url string, release chan struct{}) []*httptest.ResponseRecorder {
+	recs := make([]*httptest.ResponseRecorder, n)
+
+	var wg sync.WaitGroup
+	for i := range recs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			recs[i] = get(c, url, nil)
+		}(i)
+
+		if i == 0 {
+			// Let the leader reach the upstream before the waiters join.
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	// Let the waiters join before the leader answers.
+	time.Sleep(50 * time.Millisecond)
+	if release != nil {
+		close(release)
+	}
+	wg.Wait()
+
+	return recs
+}
+
+// expire makes the stored entry of url stale.
+func expire(t *testing.T, c *Cache, url string) {
+	t.Helper()
+
+	key, err := c.key(httptest.NewRequest(http.MethodGet, url, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := c.cacheRepo.Get(key)
+	if err != nil || value == nil {
+		t.Fatalf("Get stored entry = %+v, %v", value, err)
+	}
+
+	value.Expires = time.Now().Unix() - 1
+	if err := c.cacheRepo.SetExpires(key, time.Now().Add(time.Hour), *value); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCoalesceLeaderWaiters(t *testing.T) {
+	h := &countingHandler{release: make(chan struct{}), serve: func(_ int32, rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=60")
+		rw.Write([]byte("hello"))
+	}}
+	c := newTestCache(t, h, func(config *model.Config) { config.Coalesce.Enable = true })
+
+	recs := getConcurrently(c, 10, "http://example.com/a", h.release)
+
+	if h.count() != 1 {
+		t.Errorf("%d upstream calls, want 1", h.count())
+	}
+
+	collapsed := 0
+	for _, rec := range recs {
+		if rec.Code != http.StatusOK || rec.Body.String() != "hello" {
+			t.Errorf("response %d %q, want 200 hello", rec.Code, rec.Body.String())
+		}
+
+		if strings.Contains(rec.Header().Get("Cache-Status"), "collapsed") {
+			collapsed++
+		}
+	}
+
+	if collapsed != 9 {
+		t.Errorf("%d collapsed responses, want 9", collapsed)
+	}
+}
+
+func TestCoalesceUncacheableLeader(t *testing.T) {
+	h := &countingHandler{release: make(chan struct{}), serve: func(_ int32, rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Cache-Control", "no-store")
+		rw.Write([]byte("private"))
+	}}
+	c := newTestCache(t, h, func(config *model.Config) { config.Coalesce.Enable = true })
+
+	recs := getConcurrently(c, 5, "http://example.com/a", h.release)
+
+	// Nothing was stored to share: every waiter went upstream itself.
+	if h.count() != 5 {
+		t.Errorf("%d upstream calls, want 5", h.count())
+	}
+
+	for _, rec := range recs {
+		if rec.Body.String() != "private" || strings.Contains(rec.Header().Get("Cache-Status"), "collapsed") {
+			t.Errorf("response %q, Cache-Status %q", rec.Body.String(), rec.Header().Get("Cache-Status"))
+		}
+	}
+}
+
+func TestCoalesceWaiterTimeout(t *testing.T) {
+	h := &countingHandler{release: make(chan struct{}), serve: func(_ int32, rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=60")
+		rw.Write([]byte("hello"))
+	}}
+	c := newTestCache(t, h, func(config *model.Config) {
+		config.Coalesce.Enable = true
+		config.Coalesce.MaxWait = 1
+	})
+
+	leader := make(chan *httptest.ResponseRecorder)
+	go func() { leader <- get(c, "http://example.com/a", nil) }()
+	time.Sleep(20 * time.Millisecond)
+
+	start := time.Now()
+	rec := get(c, "http://example.com/a", nil)
+	if waited := time.Since(start); waited < time.Second {
+		t.Errorf("waiter answered after %v, before maxWait", waited)
+	}
+
+	if h.count() != 2 || rec.Body.String() != "hello" || strings.Contains(rec.Header().Get("Cache-Status"), "collapsed") {
+		t.Errorf("%d upstream calls, waiter response %q %q, want the waiter to go upstream", h.count(), rec.Body.String(), rec.Header().Get("Cache-Status"))
+	}
+
+	close(h.release)
+	if rec := <-leader; rec.Body.String() != "hello" {
+		t.Errorf("leader response %q", rec.Body.String())
+	}
+}
+
+func TestCoalesceVaryWaiter(t *testing.T) {
+	h := &countingHandler{release: make(chan struct{}), serve: func(_ int32, rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=60")
+		rw.Header().Set("Vary", "Accept-Language")
+		rw.Write([]byte(req.Header.Get("Accept-Language")))
+	}}
+	c := newTestCache(t, h, func(config *model.Config) { config.Coalesce.Enable = true })
+
+	// Nothing is stored yet: both requests share the flight of the URI, but the response
+	// of the leader varies on a header the waiter sent differently.
+	recs := make(chan *httptest.ResponseRecorder, 2)
+	go func() { recs <- get(c, "http://example.com/a", http.Header{"Accept-Language": {"en"}}) }()
+	time.Sleep(20 * time.Millisecond)
+	go func() { recs <- get(c, "http://example.com/a", http.Header{"Accept-Language": {"vi"}}) }()
+	time.Sleep(50 * time.Millisecond)
+	close(h.release)
+
+	bodies := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		rec := <-recs
+		bodies[rec.Body.String()] = true
+	}
+
+	if h.count() != 2 || !bodies["en"] || !bodies["vi"] {
+		t.Errorf("%d upstream calls, bodies %v, want each variant from the upstream", h.count(), bodies)
+	}
+
+	// Once the Vary index is stored, requests are keyed on the variant and share it.
+	if rec := get(c, "http://example.com/a", http.Header{"Accept-Language": {"vi"}}); rec.Body.String() != "vi" || h.count() != 2 {
+		t.Errorf("hit %q after %d upstream calls", rec.Body.String(), h.count())
+	}
+}
+
+func TestCoalesceStaleRevalidation(t *testing.T) {
+	h := &countingHandler{serve: func(n int32, rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=60")
+		rw.Header().Set("ETag", `"v1"`)
+		if n > 1 && req.Header.Get("If-None-Match") == `"v1"` {
+			// Slow enough for every request to join the revalidation.
+			time.Sleep(100 * time.Millisecond)
+			rw.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		rw.Write([]byte("hello"))
+	}}
+	c := newTestCache(t, h, func(config *model.Config) {
+		config.Coalesce.Enable = true
+		config.Stale.Keep = 3600
+	})
+
+	get(c, "http://example.com/a", nil)
+	expire(t, c, "http://example.com/a")
+
+	recs := getConcurrently(c, 10, "http://example.com/a", nil)
+
+	if h.count() != 2 {
+		t.Errorf("%d upstream calls, want 1 revalidation", h.count()-1)
+	}
+
+	collapsed := 0
+	for _, rec := range recs {
+		if rec.Code != http.StatusOK || rec.Body.String() != "hello" {
+			t.Errorf("response %d %q, want 200 hello", rec.Code, rec.Body.String())
+		}
+
+		if strings.Contains(rec.Header().Get("Cache-Status"), "collapsed") {
+			collapsed++
+		}
+	}
+
+	if collapsed != 9 {
+		t.Errorf("%d collapsed responses, want 9", collapsed)
+	}
+
+	// The revalidation refreshed the entry.
+	if rec := get(c, "http://example.com/a", nil); !strings.Contains(rec.Header().Get("Cache-Status"), "hit") || h.count() != 2 {
+		t.Errorf("Cache-Status %q after the revalidation", rec.Header().Get("Cache-Status"))
+	}
+}
+
+func TestStaleIfError(t *testing.T) {
+	h := &countingHandler{serve: func(n int32, rw http.ResponseWriter, _ *http.Request) {
+		if n > 1 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		rw.Header().Set("Cache-Control", "max-age=60, stale-if-error=300")
+		rw.Write([]byte("hello"))
+	}}
+	c := newTestCache(t, h, func(config *model.Config) { config.Coalesce.Enable = true })
+
+	get(c, "http://example.com/a", nil)
+	expire(t, c, "http://example.com/a")
+
+	rec := get(c, "http://example.com/a", nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello" {
+		t.Errorf("response %d %q, want the stale entry", rec.Code, rec.Body.String())
+	}
+
+	if status := rec.Header().Get("Cache-Status"); !strings.Contains(status, "fwd=stale") || !strings.Contains(status, "fwd-status=503") {
+		t.Errorf("Cache-Status %q, want a stale answer to the 503", status)
+	}
+}
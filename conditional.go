@@ -0,0 +1,109 @@
+package traefik_cache
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/ghnexpress/traefik-cache/model"
+)
+
+// representationHeaders describe the body and are not sent with a 304.
+var representationHeaders = []string{"Content-Length", "Content-Type", "Content-Encoding", "Content-Range", "Transfer-Encoding"}
+
+// notModified evaluates the client preconditions against the cached response
+// (RFC 9110 §13.2.2): If-None-Match takes precedence over If-Modified-Since.
+func notModified(req *http.Request, value *model.Cache) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+
+	if value.Status != http.StatusOK {
+		return false
+	}
+
+	header := http.Header(value.Headers)
+
+	if inm := req.Header.Values("If-None-Match"); len(inm) > 0 {
+		etag := value.ETag
+		if etag == "" {
+			etag = header.Get("ETag")
+		}
+
+		return etagWeakMatch(strings.Join(inm, ","), etag)
+	}
+
+	ims := req.Header.Get("If-Modified-Since")
+	if ims == "" {
+		return false
+	}
+
+	lastModified := value.LastModified
+	if lastModified == "" {
+		lastModified = header.Get("Last-Modified")
+	}
+
+	since, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+
+	modified, err := http.ParseTime(lastModified)
+	if err != nil {
+		return false
+	}
+
+	return !modified.After(since)
+}
+
+// etagWeakMatch reports whether etag matches one of the entity-tags of the
+// If-None-Match list using the weak comparison.
+func etagWeakMatch(list, etag string) bool {
+	// The cached response is a current representation.
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+
+	if etag == "" {
+		return false
+	}
+
+	opaque := strings.TrimPrefix(etag, "W/")
+	for {
+		list = strings.TrimLeft(list, " \t,")
+		if list == "" {
+			return false
+		}
+
+		tag, rest := scanETag(list)
+		if tag == "" {
+			return false
+		}
+
+		if strings.TrimPrefix(tag, "W/") == opaque {
+			return true
+		}
+
+		list = rest
+	}
+}
+
+// scanETag returns the entity-tag at the start of s and the remaining string.
+func scanETag(s string) (string, string) {
+	start := 0
+	if strings.HasPrefix(s, "W/") {
+		start = 2
+	}
+
+	if len(s) <= start || s[start] != '"' {
+		return "", ""
+	}
+
+	end := strings.IndexByte(s[start+1:], '"')
+	if end < 0 {
+		return "", ""
+	}
+
+	end += start + 2
+
+	return s[:end], s[end:]
+}
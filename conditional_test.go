@@ -0,0 +1,107 @@
+package traefik_cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ghnexpress/traefik-cache/model"
+)
+
+func TestETagWeakMatch(t *testing.T) {
+	tests := []struct {
+		list string
+		etag string
+		want bool
+	}{
+		{`"v1"`, `"v1"`, true},
+		{`W/"v1"`, `"v1"`, true},
+		{`"v1"`, `W/"v1"`, true},
+		{`W/"v1"`, `W/"v1"`, true},
+		{`"v0", "v1"`, `"v1"`, true},
+		{`"v0",W/"v1"`, `"v1"`, true},
+		{`"v0"`, `"v1"`, false},
+		{`"v1`, `"v1"`, false},
+		{`v1`, `"v1"`, false},
+		{`"a,b"`, `"a,b"`, true},
+		{`"a,b"`, `"a"`, false},
+		{`*`, `"v1"`, true},
+		{` * `, ``, true},
+		{`"v1"`, ``, false},
+	}
+
+	for _, tt := range tests {
+		if got := etagWeakMatch(tt.list, tt.etag); got != tt.want {
+			t.Errorf("etagWeakMatch(%q, %q) = %v, want %v", tt.list, tt.etag, got, tt.want)
+		}
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	value := &model.Cache{
+		Status:       http.StatusOK,
+		ETag:         `"v1"`,
+		LastModified: "Tue, 14 Nov 2023 22:13:20 GMT",
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		header http.Header
+		value  *model.Cache
+		want   bool
+	}{
+		{name: "no precondition", header: http.Header{}},
+		{name: "If-None-Match match", header: http.Header{"If-None-Match": {`W/"v1"`}}, want: true},
+		{name: "If-None-Match star", header: http.Header{"If-None-Match": {"*"}}, want: true},
+		{name: "If-None-Match list over several headers", header: http.Header{"If-None-Match": {`"v0"`, `"v1"`}}, want: true},
+		{name: "If-None-Match mismatch", header: http.Header{"If-None-Match": {`"v2"`}}},
+		{name: "If-Modified-Since equal", header: http.Header{"If-Modified-Since": {"Tue, 14 Nov 2023 22:13:20 GMT"}}, want: true},
+		{name: "If-Modified-Since later", header: http.Header{"If-Modified-Since": {"Wed, 15 Nov 2023 22:13:20 GMT"}}, want: true},
+		{name: "If-Modified-Since earlier", header: http.Header{"If-Modified-Since": {"Mon, 13 Nov 2023 22:13:20 GMT"}}},
+		{name: "If-Modified-Since invalid", header: http.Header{"If-Modified-Since": {"yesterday"}}},
+		{
+			name:   "If-None-Match takes precedence over a matching If-Modified-Since",
+			header: http.Header{"If-None-Match": {`"v2"`}, "If-Modified-Since": {"Wed, 15 Nov 2023 22:13:20 GMT"}},
+		},
+		{
+			name:   "If-None-Match takes precedence over a failing If-Modified-Since",
+			header: http.Header{"If-None-Match": {`"v1"`}, "If-Modified-Since": {"Mon, 13 Nov 2023 22:13:20 GMT"}},
+			want:   true,
+		},
+		{name: "HEAD", method: http.MethodHead, header: http.Header{"If-None-Match": {`"v1"`}}, want: true},
+		{name: "POST", method: http.MethodPost, header: http.Header{"If-None-Match": {`"v1"`}}},
+		{
+			name:   "validators from the stored headers",
+			header: http.Header{"If-None-Match": {`"v3"`}},
+			value:  &model.Cache{Status: http.StatusOK, Headers: map[string][]string{"Etag": {`"v3"`}}},
+			want:   true,
+		},
+		{
+			name:   "not a 200",
+			header: http.Header{"If-None-Match": {`"v1"`}},
+			value:  &model.Cache{Status: http.StatusNotFound, ETag: `"v1"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+
+			req := httptest.NewRequest(method, "http://example.com/", nil)
+			req.Header = tt.header
+
+			v := tt.value
+			if v == nil {
+				v = value
+			}
+
+			if got := notModified(req, v); got != tt.want {
+				t.Errorf("notModified = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,7 +3,26 @@ package constants
 type CacheStatus string
 
 const (
-	HitCacheStatus   CacheStatus = "hit"
-	MissCacheStatus  CacheStatus = "miss"
-	ErrorCacheStatus CacheStatus = "error"
+	HitCacheStatus    CacheStatus = "hit"
+	MissCacheStatus   CacheStatus = "miss"
+	ErrorCacheStatus  CacheStatus = "error"
+	StaleCacheStatus  CacheStatus = "stale"
+	BypassCacheStatus CacheStatus = "bypass"
+)
+
+type StorageType string
+
+const (
+	MemcachedStorageType StorageType = "memcached"
+	MemoryStorageType    StorageType = "memory"
+	RedisStorageType     StorageType = "redis"
+)
+
+type BanType string
+
+const (
+	URLBanType    BanType = "url"
+	PrefixBanType BanType = "prefix"
+	TagBanType    BanType = "tag"
+	RegexBanType  BanType = "regex"
 )
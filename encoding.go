@@ -0,0 +1,236 @@
+package traefik_cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const defaultCompressMinSize = 1024 // byte
+
+// compressibleTypes are the media types worth compressing, along with text/* and the
+// +json / +xml structured syntax suffixes.
+var compressibleTypes = []string{
+	"application/json",
+	"application/javascript",
+	"application/x-javascript",
+	"application/xml",
+	"application/xhtml+xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// decodeBody turns the upstream body into the identity representation stored in the cache,
+// updating the representation headers. It returns false for content codings that cannot
+// be decoded, such as br or zstd, and for bodies decoding to more than maxSize bytes.
+func decodeBody(header http.Header, body []byte, maxSize int) ([]byte, bool) {
+	codings := strings.Split(header.Get("Content-Encoding"), ",")
+
+	decoded := false
+	for i := len(codings) - 1; i >= 0; i-- {
+		var r io.ReadCloser
+		var err error
+
+		switch strings.ToLower(strings.TrimSpace(codings[i])) {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			r, err = gzip.NewReader(bytes.NewReader(body))
+		case "deflate":
+			r, err = zlib.NewReader(bytes.NewReader(body))
+		default:
+			// Not in decodableCoding.
+			return nil, false
+		}
+
+		if err != nil {
+			return nil, false
+		}
+
+		body, err = ioutil.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+		r.Close()
+		if err != nil || len(body) > maxSize {
+			return nil, false
+		}
+
+		decoded = true
+	}
+
+	if decoded {
+		header.Del("Content-Encoding")
+		header.Del("Content-Length")
+		weakenETag(header)
+	}
+
+	return body, true
+}
+
+// encodeBody compresses the stored identity body according to the request Accept-Encoding
+// and sets the representation headers to match the returned bytes.
+func (c *Cache) encodeBody(req *http.Request, header http.Header, body []byte) []byte {
+	header.Del("Content-Encoding")
+	header.Del("Content-Length")
+
+	minSize := c.config.Compress.MinSize
+	if minSize <= 0 {
+		minSize = defaultCompressMinSize
+	}
+
+	if !c.config.Compress.Enable || len(body) < minSize || !compressible(header.Get("Content-Type")) {
+		setContentLength(header, body)
+		return body
+	}
+
+	addVary(header, "Accept-Encoding")
+
+	var buf bytes.Buffer
+	var w io.WriteCloser
+
+	switch negotiateEncoding(req.Header.Values("Accept-Encoding")) {
+	case "gzip":
+		header.Set("Content-Encoding", "gzip")
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		header.Set("Content-Encoding", "deflate")
+		w = zlib.NewWriter(&buf)
+	default:
+		setContentLength(header, body)
+		return body
+	}
+
+	if _, err := w.Write(body); err != nil || w.Close() != nil {
+		header.Del("Content-Encoding")
+		setContentLength(header, body)
+		return body
+	}
+
+	weakenETag(header)
+	setContentLength(header, buf.Bytes())
+
+	return buf.Bytes()
+}
+
+// restrictAcceptEncoding keeps the codings of the Accept-Encoding header that decodeBody can
+// decode, so that the upstream does not answer a cache candidate with br or zstd, which
+// could not be stored. The identity representation is asked for when none is left.
+func restrictAcceptEncoding(header http.Header) {
+	values := header.Values("Accept-Encoding")
+	if len(values) == 0 {
+		return
+	}
+
+	var kept []string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			part = strings.TrimSpace(part)
+			coding := strings.ToLower(strings.TrimSpace(strings.Split(part, ";")[0]))
+			if coding != "" && decodableCoding(coding) {
+				kept = append(kept, part)
+			}
+		}
+	}
+
+	if len(kept) == 0 {
+		header.Set("Accept-Encoding", "identity")
+		return
+	}
+
+	header.Set("Accept-Encoding", strings.Join(kept, ", "))
+}
+
+// decodable tells whether decodeBody supports every content coding of the response.
+func decodable(header http.Header) bool {
+	for _, coding := range strings.Split(header.Get("Content-Encoding"), ",") {
+		if !decodableCoding(strings.ToLower(strings.TrimSpace(coding))) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func decodableCoding(coding string) bool {
+	switch coding {
+	case "", "identity", "gzip", "x-gzip", "deflate":
+		return true
+	default:
+		return false
+	}
+}
+
+// negotiateEncoding picks gzip or deflate from the Accept-Encoding values, preferring gzip
+// at equal quality. It returns "" when the identity representation must be sent.
+func negotiateEncoding(acceptEncoding []string) string {
+	qualities := make(map[string]float64)
+
+	for _, v := range acceptEncoding {
+		for _, part := range strings.Split(v, ",") {
+			fields := strings.Split(part, ";")
+			coding := strings.ToLower(strings.TrimSpace(fields[0]))
+			if coding == "" {
+				continue
+			}
+
+			q := 1.0
+			for _, param := range fields[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					if f, err := strconv.ParseFloat(param[2:], 64); err == nil {
+						q = f
+					}
+				}
+			}
+
+			qualities[coding] = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range []string{"gzip", "deflate"} {
+		q, ok := qualities[coding]
+		if !ok {
+			q, ok = qualities["*"]
+		}
+
+		if ok && q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+
+	return best
+}
+
+func compressible(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+
+	if strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+
+	for _, t := range compressibleTypes {
+		if mediaType == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+// weakenETag marks a strong ETag as weak: it no longer identifies the exact bytes once the
+// content coding changed.
+func weakenETag(header http.Header) {
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
+	}
+}
+
+func setContentLength(header http.Header, body []byte) {
+	if len(body) > 0 {
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+}
@@ -0,0 +1,160 @@
+package traefik_cache
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ghnexpress/traefik-cache/constants"
+	"github.com/ghnexpress/traefik-cache/model"
+)
+
+const (
+	defaultPurgeMethod  = "PURGE"
+	defaultBanMethod    = "BAN"
+	defaultSecretHeader = "X-Cache-Secret"
+	BAN_REGEX_HEADER    = "X-Ban-Regex"
+)
+
+func (c *Cache) purgeMethod() string {
+	if c.config.Invalidation.PurgeMethod != "" {
+		return c.config.Invalidation.PurgeMethod
+	}
+
+	return defaultPurgeMethod
+}
+
+func (c *Cache) banMethod() string {
+	if c.config.Invalidation.BanMethod != "" {
+		return c.config.Invalidation.BanMethod
+	}
+
+	return defaultBanMethod
+}
+
+func (c *Cache) isInvalidation(req *http.Request) bool {
+	return c.config.Invalidation.Enable && (req.Method == c.purgeMethod() || req.Method == c.banMethod())
+}
+
+// serveInvalidation handles the Varnish-style methods: PURGE deletes the entry of the key
+// built for the GET request of the same URL, BAN registers a ban on the URL prefix or on
+// the regex sent in the X-Ban-Regex header.
+func (c *Cache) serveInvalidation(rw http.ResponseWriter, req *http.Request) {
+	requestID := req.Header.Get(X_REQUEST_ID_HEADER)
+
+	if !c.invalidationAllowed(req) {
+		writeJSON(rw, http.StatusForbidden, map[string]string{"error": "forbidden"})
+		return
+	}
+
+	if req.Method == c.purgeMethod() {
+		purged, err := c.purgeKey(req)
+		if err != nil {
+			c.log.Alert(requestID, err)
+			writeJSON(rw, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+
+		writeJSON(rw, http.StatusOK, map[string]int{"purged": purged})
+		return
+	}
+
+	ban := model.Ban{Type: string(constants.PrefixBanType), Value: req.URL.RequestURI(), CreatedAt: time.Now().UnixNano()}
+	if pattern := req.Header.Get(BAN_REGEX_HEADER); pattern != "" {
+		if _, err := banRegexp(pattern); err != nil {
+			writeJSON(rw, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid regex: %v", err)})
+			return
+		}
+
+		ban = model.Ban{Type: string(constants.RegexBanType), Value: pattern, CreatedAt: ban.CreatedAt}
+	}
+
+	if err := c.addBans(req.Host, ban); err != nil {
+		c.log.Alert(requestID, fmt.Errorf("Register ban error: %v", err))
+		writeJSON(rw, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, map[string]any{"registered": true, "ban": ban})
+}
+
+// purgeKey deletes the entry of the GET request of the same URL and returns the number
+// of entries removed.
+func (c *Cache) purgeKey(req *http.Request) (int, error) {
+	get := req.Clone(req.Context())
+	get.Method = http.MethodGet
+
+	// The headers only sent with invalidations would not match the key of the GET request.
+	get.Header.Del(c.secretHeader())
+	get.Header.Del(BAN_REGEX_HEADER)
+
+	key, err := c.key(get)
+	if err != nil {
+		return 0, fmt.Errorf("Build key memcached error: %v", err)
+	}
+
+	value, err := c.cacheRepo.Get(key)
+	if err != nil || value == nil {
+		return 0, err
+	}
+
+	if err := c.cacheRepo.Delete(key); err != nil {
+		return 0, err
+	}
+
+	return 1, nil
+}
+
+// invalidationAllowed checks the client IP against invalidation.allowIps, or else the
+// shared secret header.
+func (c *Cache) invalidationAllowed(req *http.Request) bool {
+	cfg := c.config.Invalidation
+
+	if cfg.AllowIPs != "" {
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			host = req.RemoteAddr
+		}
+
+		if ip := net.ParseIP(host); ip != nil && ipAllowed(ip, cfg.AllowIPs) {
+			return true
+		}
+	}
+
+	if cfg.Secret == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(req.Header.Get(c.secretHeader())), []byte(cfg.Secret)) == 1
+}
+
+func (c *Cache) secretHeader() string {
+	if c.config.Invalidation.SecretHeader != "" {
+		return c.config.Invalidation.SecretHeader
+	}
+
+	return defaultSecretHeader
+}
+
+// ipAllowed matches ip against a comma-separated list of IPs and CIDRs.
+func ipAllowed(ip net.IP, allowIPs string) bool {
+	for _, allowed := range strings.Split(allowIPs, ",") {
+		allowed = strings.TrimSpace(allowed)
+
+		if _, ipNet, err := net.ParseCIDR(allowed); err == nil {
+			if ipNet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		if allowedIP := net.ParseIP(allowed); allowedIP != nil && allowedIP.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
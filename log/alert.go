@@ -0,0 +1,149 @@
+package log
+
+import (
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAlertInterval  = 60 // second
+	defaultAlertQueueSize = 256
+	maxSignatureLength    = 200
+)
+
+// signatureState tracks the alerts of one signature within the rate limit interval.
+type signatureState struct {
+	lastSent   time.Time
+	suppressed int
+	env        string
+	requestID  string
+	text       string
+}
+
+// dispatcher sends alerts from a background goroutine. Alerts of a signature already sent
+// within the interval are only counted, the count being reported with the next alert of
+// that signature or by the periodic flush. Alerts are dropped when the queue is full, so
+// that a request never waits on alerting.
+type dispatcher struct {
+	send     func(a Alert)
+	warn     func(msg string, fields Fields)
+	interval time.Duration
+	queue    chan Alert
+
+	mu         sync.Mutex
+	signatures map[string]*signatureState
+	dropped    int
+}
+
+func newDispatcher(send func(a Alert), warn func(msg string, fields Fields), interval time.Duration, queueSize int) *dispatcher {
+	d := &dispatcher{
+		send:       send,
+		warn:       warn,
+		interval:   interval,
+		queue:      make(chan Alert, queueSize),
+		signatures: make(map[string]*signatureState),
+	}
+
+	go d.run()
+	go d.flushLoop()
+
+	return d
+}
+
+func (d *dispatcher) enqueue(env, requestID, text string) {
+	sig := signature(text)
+	now := time.Now()
+
+	d.mu.Lock()
+	st, ok := d.signatures[sig]
+	if !ok {
+		st = &signatureState{}
+		d.signatures[sig] = st
+	}
+
+	if ok && now.Sub(st.lastSent) < d.interval {
+		st.suppressed++
+		st.env, st.requestID, st.text = env, requestID, text
+		d.mu.Unlock()
+		return
+	}
+
+	a := Alert{Env: env, RequestID: requestID, Message: text, Suppressed: st.suppressed, Time: now}
+	st.lastSent, st.suppressed = now, 0
+	d.mu.Unlock()
+
+	d.push(a)
+}
+
+func (d *dispatcher) push(a Alert) {
+	select {
+	case d.queue <- a:
+	default:
+		d.mu.Lock()
+		d.dropped++
+		d.mu.Unlock()
+	}
+}
+
+func (d *dispatcher) run() {
+	for a := range d.queue {
+		d.send(a)
+	}
+}
+
+// flushLoop reports the alerts suppressed once their interval elapsed without a new alert
+// of the same signature, and forgets the signatures idle for a while.
+func (d *dispatcher) flushLoop() {
+	tick := d.interval / 4
+	if tick < time.Second {
+		tick = time.Second
+	}
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		var pending []Alert
+
+		d.mu.Lock()
+		for sig, st := range d.signatures {
+			if now.Sub(st.lastSent) < d.interval {
+				continue
+			}
+
+			if st.suppressed > 0 {
+				pending = append(pending, Alert{Env: st.env, RequestID: st.requestID, Message: st.text, Suppressed: st.suppressed, Time: now})
+				st.lastSent, st.suppressed = now, 0
+				continue
+			}
+
+			if now.Sub(st.lastSent) > 10*d.interval {
+				delete(d.signatures, sig)
+			}
+		}
+
+		dropped := d.dropped
+		d.dropped = 0
+		d.mu.Unlock()
+
+		if dropped > 0 {
+			d.warn("Alert queue full, alerts dropped", Fields{"dropped": dropped})
+		}
+
+		for _, a := range pending {
+			d.push(a)
+		}
+	}
+}
+
+var digitsRegexp = regexp.MustCompile(`[0-9]+`)
+
+// signature groups the alerts differing only by numbers, e.g. ports, sizes or durations.
+func signature(text string) string {
+	if len(text) > maxSignatureLength {
+		text = text[:maxSignatureLength]
+	}
+
+	return digitsRegexp.ReplaceAllString(text, "#")
+}
@@ -0,0 +1,162 @@
+package log
+
+import (
+	"testing"
+	"time"
+)
+
+// recorder records the alerts sent and the warnings of a dispatcher. Sending blocks until
+// unblock is closed when it is not nil.
+type recorder struct {
+	sent     chan Alert
+	warnings chan Fields
+	unblock  chan struct{}
+}
+
+func newRecorder() *recorder {
+	return &recorder{sent: make(chan Alert, 16), warnings: make(chan Fields, 16)}
+}
+
+func (r *recorder) send(a Alert) {
+	if r.unblock != nil {
+		<-r.unblock
+	}
+
+	r.sent <- a
+}
+
+func (r *recorder) warn(_ string, fields Fields) {
+	r.warnings <- fields
+}
+
+func (r *recorder) next(t *testing.T, timeout time.Duration) Alert {
+	t.Helper()
+
+	select {
+	case a := <-r.sent:
+		return a
+	case <-time.After(timeout):
+		t.Fatal("no alert sent")
+		return Alert{}
+	}
+}
+
+func (r *recorder) none(t *testing.T, wait time.Duration) {
+	t.Helper()
+
+	select {
+	case a := <-r.sent:
+		t.Fatalf("unexpected alert %+v", a)
+	case <-time.After(wait):
+	}
+}
+
+func TestDispatcherDedup(t *testing.T) {
+	r := newRecorder()
+	d := newDispatcher(r.send, r.warn, time.Hour, 8)
+
+	d.enqueue("dev", "req-1", "Get data from memcached error: dial tcp 10.0.0.1:11211")
+	for i := 2; i <= 4; i++ {
+		// The same alert but for numbers.
+		d.enqueue("dev", "req-2", "Get data from memcached error: dial tcp 10.0.0.2:11211")
+	}
+	d.enqueue("dev", "req-5", "Set data to memcached error: timeout")
+
+	first := r.next(t, time.Second)
+	if first.RequestID != "req-1" || first.Suppressed != 0 || first.Env != "dev" {
+		t.Errorf("first alert %+v", first)
+	}
+
+	if other := r.next(t, time.Second); other.RequestID != "req-5" {
+		t.Errorf("alert of another signature %+v", other)
+	}
+
+	r.none(t, 100*time.Millisecond)
+}
+
+func TestDispatcherSuppressedCount(t *testing.T) {
+	r := newRecorder()
+	d := newDispatcher(r.send, r.warn, 200*time.Millisecond, 8)
+
+	d.enqueue("dev", "req-1", "upstream error")
+	r.next(t, time.Second)
+
+	d.enqueue("dev", "req-2", "upstream error")
+	d.enqueue("dev", "req-3", "upstream error")
+	r.none(t, 50*time.Millisecond)
+
+	// Past the interval, the next alert reports the ones suppressed meanwhile.
+	time.Sleep(200 * time.Millisecond)
+	d.enqueue("dev", "req-4", "upstream error")
+
+	a := r.next(t, time.Second)
+	if a.RequestID != "req-4" || a.Suppressed != 2 {
+		t.Errorf("alert %+v, want req-4 reporting 2 suppressed", a)
+	}
+}
+
+func TestDispatcherPeriodicFlush(t *testing.T) {
+	r := newRecorder()
+	d := newDispatcher(r.send, r.warn, 200*time.Millisecond, 8)
+
+	d.enqueue("dev", "req-1", "upstream error")
+	r.next(t, time.Second)
+
+	d.enqueue("dev", "req-2", "upstream error")
+	d.enqueue("dev", "req-3", "upstream error")
+
+	// Without a new alert of the signature, the flush reports the suppressed ones with the
+	// last of them.
+	a := r.next(t, 3*time.Second)
+	if a.RequestID != "req-3" || a.Suppressed != 2 || a.Message != "upstream error" {
+		t.Errorf("flushed alert %+v, want req-3 reporting 2 suppressed", a)
+	}
+
+	r.none(t, 1200*time.Millisecond)
+}
+
+func TestDispatcherDropOnFull(t *testing.T) {
+	r := newRecorder()
+	r.unblock = make(chan struct{})
+	// The drops are reported by the flush, every second at this interval.
+	d := newDispatcher(r.send, r.warn, 2*time.Second, 1)
+
+	// The first alert is taken by the blocked sender, the second fills the queue.
+	d.enqueue("dev", "req-1", "first error")
+	time.Sleep(20 * time.Millisecond)
+	d.enqueue("dev", "req-2", "second error")
+
+	start := time.Now()
+	d.enqueue("dev", "req-3", "third error")
+	d.enqueue("dev", "req-4", "fourth error")
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("enqueue blocked for %v on a full queue", elapsed)
+	}
+
+	select {
+	case fields := <-r.warnings:
+		if fields["dropped"] != 2 {
+			t.Errorf("warning fields %v, want 2 dropped", fields)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no warning about the dropped alerts")
+	}
+
+	close(r.unblock)
+	for _, want := range []string{"req-1", "req-2"} {
+		if a := r.next(t, time.Second); a.RequestID != want {
+			t.Errorf("alert %+v, want %s", a, want)
+		}
+	}
+	r.none(t, 50*time.Millisecond)
+}
+
+func TestSignature(t *testing.T) {
+	if signature("timeout after 150ms on 10.0.0.1") != signature("timeout after 3000ms on 10.0.0.12") {
+		t.Error("alerts differing by numbers have different signatures")
+	}
+
+	if signature("Get error") == signature("Set error") {
+		t.Error("different alerts have the same signature")
+	}
+}
@@ -1,53 +1,268 @@
 package log
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"net/url"
 	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/ghnexpress/traefik-cache/model"
 )
 
+type Level int
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+func (lv Level) String() string {
+	switch lv {
+	case DebugLevel:
+		return "debug"
+	case WarnLevel:
+		return "warn"
+	case ErrorLevel:
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+func parseLevel(s string) Level {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return DebugLevel
+	case "warn", "warning":
+		return WarnLevel
+	case "error":
+		return ErrorLevel
+	default:
+		return InfoLevel
+	}
+}
+
+const jsonFormat = "json"
+
+// Fields are the structured context of a log line.
+type Fields map[string]any
+
+var (
+	dispatchers      = make(map[string]*dispatcher)
+	dispatchersMutex = sync.Mutex{}
+)
+
 type Log struct {
 	env      string
-	telegram model.Telegram
+	level    Level
+	json     bool
+	fields   Fields
+	redactor *redactor
+	alerts   *dispatcher
 }
 
-func New(env string, telegram model.Telegram) Log {
-	return Log{
-		env:      env,
-		telegram: telegram,
+// New returns a Log writing lines of config.Log.Format at or above config.Log.Level, and
+// sending alerts to every sink configured in config.Alert. The secrets of config are
+// masked in every line and alert.
+func New(config model.Config) (Log, error) {
+	l := Log{
+		env:      config.Env,
+		level:    parseLevel(config.Log.Level),
+		json:     strings.EqualFold(config.Log.Format, jsonFormat),
+		redactor: newRedactor(config),
+	}
+
+	sinks, err := newSinks(config.Alert)
+	if err != nil {
+		return l, err
 	}
+
+	if len(sinks) > 0 {
+		l.alerts = getDispatcher(config, func(a Alert) {
+			for _, sink := range sinks {
+				if err := sink.Send(a); err != nil {
+					l.Warn(fmt.Sprintf("Send %s alert error: %v", sink.Name(), err), Fields{"requestId": a.RequestID})
+				}
+			}
+		}, l.Warn)
+	}
+
+	return l, nil
+}
+
+// getDispatcher shares one dispatcher, and its rate limit, between all the middlewares
+// using the same alert configuration.
+func getDispatcher(config model.Config, send func(a Alert), warn func(msg string, fields Fields)) *dispatcher {
+	dispatchersMutex.Lock()
+	defer dispatchersMutex.Unlock()
+
+	key := fmt.Sprintf("%s|%+v|%+v", config.Env, config.Log, config.Alert)
+	if d, ok := dispatchers[key]; ok {
+		return d
+	}
+
+	interval := config.Alert.Interval
+	if interval <= 0 {
+		interval = defaultAlertInterval
+	}
+
+	queueSize := config.Alert.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultAlertQueueSize
+	}
+
+	d := newDispatcher(send, warn, time.Duration(interval)*time.Second, queueSize)
+	dispatchers[key] = d
+
+	return d
+}
+
+// With returns a Log adding fields to every line.
+func (l Log) With(fields Fields) Log {
+	merged := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+
+	l.fields = merged
+
+	return l
+}
+
+func (l *Log) Enabled(level Level) bool {
+	return level >= l.level
 }
 
+func (l *Log) Debug(msg string, fields Fields) {
+	l.write(DebugLevel, msg, fields)
+}
+
+func (l *Log) Info(msg string, fields Fields) {
+	l.write(InfoLevel, msg, fields)
+}
+
+func (l *Log) Warn(msg string, fields Fields) {
+	l.write(WarnLevel, msg, fields)
+}
+
+func (l *Log) Error(msg string, fields Fields) {
+	l.write(ErrorLevel, msg, fields)
+}
+
+// ConsoleLog writes value at info level.
 func (l *Log) ConsoleLog(requestID, value any) {
-	os.Stdout.WriteString(fmt.Sprintf("[cache-middleware-plugin] [%s] %v\n", requestID, value))
+	l.Info(fmt.Sprint(value), Fields{"requestId": requestID})
 }
 
-func (l *Log) TelegramLog(requestID, value any) {
-	if l.telegram.Token != "" && l.telegram.ChatID != "" {
-		params := url.Values{}
-		params.Add("chat_id", l.telegram.ChatID)
-		params.Add("text", fmt.Sprintf("[%s][cache-middleware-plugin]\nRequestID: %s\n%v", l.env, requestID, value))
-		params.Add("parse_mode", "HTML")
+// Alert writes value at error level and queues it to be sent to every alert sink,
+// without waiting for it.
+func (l *Log) Alert(requestID, value any) {
+	if l.alerts != nil {
+		l.alerts.enqueue(l.env, fmt.Sprint(requestID), l.redactor.string(fmt.Sprint(value)))
+	}
 
-		rs, errGet := http.Get(fmt.Sprintf("https://api.telegram.org/%s/sendMessage?%s", l.telegram.Token, params.Encode()))
-		if errGet != nil {
-			l.ConsoleLog(requestID, errGet.Error())
+	l.Error(fmt.Sprint(value), Fields{"requestId": requestID})
+}
+
+func (l *Log) write(level Level, msg string, fields Fields) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	all := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		all[k] = v
+	}
+	for k, v := range fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
 		}
+		all[k] = l.redactor.value(v)
+	}
 
-		if rs.StatusCode != 200 {
-			body, errRead := ioutil.ReadAll(rs.Body)
-			if errRead != nil {
-				l.ConsoleLog(requestID, errRead.Error())
-			}
+	var line string
+	if l.json {
+		line = l.jsonLine(level, msg, all)
+	} else {
+		line = l.textLine(level, msg, all)
+	}
+
+	os.Stdout.WriteString(l.redactor.string(line))
+}
 
-			rs.Body.Close()
-			l.ConsoleLog(requestID, string(body))
+// jsonLine writes time, level and msg first, then the fields sorted by name.
+func (l *Log) jsonLine(level Level, msg string, fields Fields) string {
+	var b bytes.Buffer
+	b.WriteString(`{"time":`)
+	writeJSON(&b, time.Now().Format(time.RFC3339Nano))
+	b.WriteString(`,"level":`)
+	writeJSON(&b, level.String())
+	b.WriteString(`,"msg":`)
+	writeJSON(&b, msg)
+
+	for _, k := range sortedKeys(fields) {
+		b.WriteByte(',')
+		writeJSON(&b, k)
+		b.WriteByte(':')
+		writeJSON(&b, fields[k])
+	}
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// textLine keeps the historical "[cache-middleware-plugin] [requestID] msg" layout,
+// followed by the level and the other fields as key=value.
+func (l *Log) textLine(level Level, msg string, fields Fields) string {
+	var b strings.Builder
+	if requestID, ok := fields["requestId"]; ok {
+		fmt.Fprintf(&b, "[cache-middleware-plugin] [%v] %s level=%s", requestID, msg, level)
+	} else {
+		fmt.Fprintf(&b, "[cache-middleware-plugin] [%s] %s", level, msg)
+	}
+
+	for _, k := range sortedKeys(fields) {
+		if k == "requestId" {
+			continue
+		}
+
+		v := fields[k]
+		if _, ok := v.(string); !ok {
+			if j, err := json.Marshal(v); err == nil {
+				v = string(j)
+			}
 		}
+		fmt.Fprintf(&b, " %s=%v", k, v)
+	}
+	b.WriteByte('\n')
+
+	return b.String()
+}
+
+func writeJSON(b *bytes.Buffer, v any) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		j, _ = json.Marshal(fmt.Sprint(v))
+	}
+
+	b.Write(j)
+}
+
+func sortedKeys(fields Fields) []string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
 	}
+	sort.Strings(keys)
 
-	l.ConsoleLog(requestID, value)
+	return keys
 }
@@ -0,0 +1,119 @@
+package log
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+const (
+	redacted = "[REDACTED]"
+	// minSecretLength keeps short values, likely to appear in any text, from being masked.
+	minSecretLength = 4
+)
+
+// secretKeys are the configuration fields, lowercased, whose values are never logged.
+var secretKeys = map[string]bool{
+	"token":      true,
+	"password":   true,
+	"secret":     true,
+	"webhookurl": true,
+	"headers":    true,
+	"template":   true,
+}
+
+// redactor masks secrets: the secret fields of structured values, and the secret values
+// wherever they appear in a log line.
+type redactor struct {
+	secrets  []string
+	replacer *strings.Replacer
+}
+
+// newRedactor collects the secret values of config, any value marshalling to a JSON object.
+func newRedactor(config any) *redactor {
+	r := &redactor{}
+	walkSecrets(toJSONValue(config), func(s string) {
+		if len(s) >= minSecretLength {
+			r.secrets = append(r.secrets, s)
+		}
+	})
+
+	// Longest first, so that a secret containing another one is masked whole.
+	sort.Slice(r.secrets, func(i, j int) bool { return len(r.secrets[i]) > len(r.secrets[j]) })
+
+	pairs := make([]string, 0, 2*len(r.secrets))
+	for _, s := range r.secrets {
+		pairs = append(pairs, s, redacted)
+	}
+	r.replacer = strings.NewReplacer(pairs...)
+
+	return r
+}
+
+func (r *redactor) string(s string) string {
+	if r == nil || len(r.secrets) == 0 {
+		return s
+	}
+
+	return r.replacer.Replace(s)
+}
+
+// value returns v with its secret fields masked, v itself for scalars.
+func (r *redactor) value(v any) any {
+	switch v.(type) {
+	case nil, string, bool, int, int64, uint64, float64:
+		return v
+	}
+
+	return redactFields(toJSONValue(v))
+}
+
+func toJSONValue(v any) any {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+
+	var out any
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil
+	}
+
+	return out
+}
+
+func redactFields(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, val := range t {
+			if s, ok := val.(string); ok && s != "" && secretKeys[strings.ToLower(k)] {
+				t[k] = redacted
+				continue
+			}
+			t[k] = redactFields(val)
+		}
+	case []any:
+		for i, val := range t {
+			t[i] = redactFields(val)
+		}
+	}
+
+	return v
+}
+
+func walkSecrets(v any, fn func(s string)) {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, val := range t {
+			if s, ok := val.(string); ok && secretKeys[strings.ToLower(k)] {
+				fn(s)
+				continue
+			}
+			walkSecrets(val, fn)
+		}
+	case []any:
+		for _, val := range t {
+			walkSecrets(val, fn)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package log
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ghnexpress/traefik-cache/model"
+)
+
+var testSecrets = []string{"tg-123456:ABCDEF", "redis-pass", "smtp-pass", "purge-token", "invalidation-secret"}
+
+func secretConfig() model.Config {
+	var config model.Config
+	config.Env = "dev"
+	config.Alert.Telegram = model.Telegram{ChatID: "-100200", Token: "tg-123456:ABCDEF"}
+	config.Storage.Redis = model.RedisConfig{Address: "redis:6379", Password: "redis-pass"}
+	config.Alert.SMTP = model.SMTP{Address: "smtp.example.com:587", Password: "smtp-pass"}
+	config.Purge = model.Purge{Enable: true, Token: "purge-token"}
+	config.Invalidation = model.Invalidation{Enable: true, Secret: "invalidation-secret", SecretHeader: "X-Cache-Secret"}
+
+	return config
+}
+
+// captureStdout returns what fn wrote to the standard output.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	fn()
+	w.Close()
+
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(out)
+}
+
+func TestRedactConfigLine(t *testing.T) {
+	for _, format := range []string{"text", "json"} {
+		t.Run(format, func(t *testing.T) {
+			config := secretConfig()
+			config.Log.Format = format
+
+			l := Log{json: format == jsonFormat, redactor: newRedactor(config)}
+			out := captureStdout(t, func() {
+				l.Info("Cache middleware configured", Fields{"config": &config})
+			})
+
+			for _, secret := range testSecrets {
+				if strings.Contains(out, secret) {
+					t.Errorf("startup line contains %q:\n%s", secret, out)
+				}
+			}
+
+			// Only the secrets are masked.
+			for _, kept := range []string{"redis:6379", "smtp.example.com:587", "-100200", "X-Cache-Secret", redacted} {
+				if !strings.Contains(out, kept) {
+					t.Errorf("startup line does not contain %q:\n%s", kept, out)
+				}
+			}
+		})
+	}
+}
+
+func TestRedactFreeText(t *testing.T) {
+	r := newRedactor(secretConfig())
+
+	for _, secret := range testSecrets {
+		msg := fmt.Sprintf("Send alert error: Post \"https://host/%s/send\": dial error, auth=%s", secret, secret)
+
+		got := r.string(msg)
+		if strings.Contains(got, secret) {
+			t.Errorf("string(%q) = %q, secret not masked", msg, got)
+		}
+		if strings.Count(got, redacted) != 2 {
+			t.Errorf("string(%q) = %q, want both occurrences masked", msg, got)
+		}
+	}
+
+	config := secretConfig()
+	l := Log{redactor: newRedactor(config)}
+	out := captureStdout(t, func() {
+		l.Error("AUTH failed with redis-pass", Fields{"url": "https://api.telegram.org/bottg-123456:ABCDEF/sendMessage"})
+	})
+	if strings.Contains(out, "redis-pass") || strings.Contains(out, "tg-123456:ABCDEF") {
+		t.Errorf("log line not redacted:\n%s", out)
+	}
+}
+
+func TestRedactLongestFirst(t *testing.T) {
+	var config model.Config
+	config.Purge.Token = "abcd"
+	config.Invalidation.Secret = "abcd-efgh"
+	config.Storage.Redis.Password = "abc" // too short to be masked in free text
+
+	r := newRedactor(config)
+
+	if got := r.string("secret abcd-efgh and abcd, abc"); got != "secret [REDACTED] and [REDACTED], abc" {
+		t.Errorf("string = %q", got)
+	}
+}
+
+func TestRedactNil(t *testing.T) {
+	var r *redactor
+	if got := r.string("redis-pass"); got != "redis-pass" {
+		t.Errorf("nil redactor string = %q", got)
+	}
+}
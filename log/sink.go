@@ -0,0 +1,87 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/ghnexpress/traefik-cache/model"
+)
+
+const defaultSinkTimeout = 10 // second
+
+// Alert is one alert handed to the sinks. Suppressed counts the alerts of the same
+// signature not sent since the previous one.
+type Alert struct {
+	Env        string
+	RequestID  string
+	Message    string
+	Suppressed int
+	Time       time.Time
+}
+
+// Text formats the alert as a plain text message.
+func (a Alert) Text() string {
+	text := fmt.Sprintf("[%s][cache-middleware-plugin]\nRequestID: %s\n%s", a.Env, a.RequestID, a.Message)
+	if a.Suppressed > 0 {
+		text = fmt.Sprintf("%s\n(%d similar alerts suppressed)", text, a.Suppressed)
+	}
+
+	return text
+}
+
+// AlertSink delivers alerts to a destination. Send is called from the alert dispatcher
+// goroutine, one alert at a time.
+type AlertSink interface {
+	Name() string
+	Send(a Alert) error
+}
+
+// newSinks returns a sink for every destination configured in alert.
+func newSinks(alert model.AlertConfig) ([]AlertSink, error) {
+	var sinks []AlertSink
+
+	if alert.Telegram.Token != "" && alert.Telegram.ChatID != "" {
+		sinks = append(sinks, NewTelegramSink(alert.Telegram))
+	}
+
+	if alert.Slack.WebhookURL != "" {
+		sinks = append(sinks, NewSlackSink(alert.Slack))
+	}
+
+	if alert.Webhook.URL != "" {
+		sink, err := NewWebhookSink(alert.Webhook)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	if alert.SMTP.Address != "" && alert.SMTP.To != "" {
+		sinks = append(sinks, NewSMTPSink(alert.SMTP))
+	}
+
+	return sinks, nil
+}
+
+func timeoutOrDefault(timeout int) time.Duration {
+	if timeout <= 0 {
+		timeout = defaultSinkTimeout
+	}
+
+	return time.Duration(timeout) * time.Second
+}
+
+// checkResponse turns a non-2xx response into an error holding the start of its body.
+func checkResponse(rs *http.Response) error {
+	defer rs.Body.Close()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(rs.Body, 1024))
+	if rs.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", rs.Status, body)
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/ghnexpress/traefik-cache/model"
+)
+
+// slackSink posts alerts to a Slack incoming webhook.
+type slackSink struct {
+	webhookURL string
+	client     *http.Client
+}
+
+func NewSlackSink(cfg model.Slack) AlertSink {
+	return &slackSink{
+		webhookURL: cfg.WebhookURL,
+		client:     &http.Client{Timeout: timeoutOrDefault(0)},
+	}
+}
+
+func (s *slackSink) Name() string {
+	return "slack"
+}
+
+func (s *slackSink) Send(a Alert) error {
+	body, err := json.Marshal(map[string]string{"text": a.Text()})
+	if err != nil {
+		return err
+	}
+
+	rs, err := s.client.Post(s.webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	return checkResponse(rs)
+}
@@ -0,0 +1,48 @@
+package log
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ghnexpress/traefik-cache/model"
+)
+
+func TestSlackSink(t *testing.T) {
+	var contentType string
+	var payload map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	a := testAlert()
+	if err := NewSlackSink(model.Slack{WebhookURL: srv.URL}).Send(a); err != nil {
+		t.Fatal(err)
+	}
+
+	if contentType != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", contentType)
+	}
+	if payload["text"] != a.Text() {
+		t.Errorf("text = %q, want %q", payload["text"], a.Text())
+	}
+}
+
+func TestSlackSinkError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("no_service"))
+	}))
+	defer srv.Close()
+
+	err := NewSlackSink(model.Slack{WebhookURL: srv.URL}).Send(testAlert())
+	if err == nil || err.Error() != "404 Not Found: no_service" {
+		t.Errorf("Send error = %v, want 404 Not Found: no_service", err)
+	}
+}
@@ -0,0 +1,136 @@
+package log
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/ghnexpress/traefik-cache/model"
+)
+
+// smtpSink mails alerts, upgrading the connection with STARTTLS when the server offers it.
+type smtpSink struct {
+	address  string
+	username string
+	password string
+	from     string
+	to       []string
+	timeout  time.Duration
+}
+
+func NewSMTPSink(cfg model.SMTP) AlertSink {
+	var to []string
+	for _, addr := range strings.Split(cfg.To, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			to = append(to, addr)
+		}
+	}
+
+	from := cfg.From
+	if from == "" {
+		from = cfg.Username
+	}
+
+	return &smtpSink{
+		address:  cfg.Address,
+		username: cfg.Username,
+		password: cfg.Password,
+		from:     from,
+		to:       to,
+		timeout:  timeoutOrDefault(cfg.Timeout),
+	}
+}
+
+func (s *smtpSink) Name() string {
+	return "smtp"
+}
+
+func (s *smtpSink) Send(a Alert) error {
+	host, _, err := net.SplitHostPort(s.address)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("tcp", s.address, s.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// net/smtp has no timeout of its own.
+	if err := conn.SetDeadline(time.Now().Add(s.timeout)); err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+
+	if s.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.username, s.password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(s.from); err != nil {
+		return err
+	}
+	for _, addr := range s.to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(s.message(a)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+func (s *smtpSink) message(a Alert) []byte {
+	subject := fmt.Sprintf("[%s][cache-middleware-plugin] %s", a.Env, firstLine(a.Message))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", s.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", a.Time.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(a.Text(), "\n", "\r\n"))
+	b.WriteString("\r\n")
+
+	return []byte(b.String())
+}
+
+// firstLine keeps a subject on one line and short.
+func firstLine(s string) string {
+	if i := strings.IndexAny(s, "\r\n"); i >= 0 {
+		s = s[:i]
+	}
+	if len(s) > 120 {
+		s = s[:120]
+	}
+
+	return s
+}
@@ -0,0 +1,155 @@
+package log
+
+import (
+	"encoding/base64"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ghnexpress/traefik-cache/model"
+)
+
+// smtpServer is an in-process stand-in for an SMTP server offering AUTH PLAIN without TLS,
+// which net/smtp accepts on localhost. It records the mails it receives.
+type smtpServer struct {
+	ln net.Listener
+	// rejectRcpt is the recipient refused with a 550 reply.
+	rejectRcpt string
+
+	mu    sync.Mutex
+	auth  string
+	from  string
+	rcpts []string
+	data  string
+}
+
+func newSMTPServer(t *testing.T, rejectRcpt string) *smtpServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &smtpServer{ln: ln, rejectRcpt: rejectRcpt}
+	go s.serve()
+
+	return s
+}
+
+func (s *smtpServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.handle(textproto.NewConn(conn))
+	}
+}
+
+func (s *smtpServer) handle(c *textproto.Conn) {
+	defer c.Close()
+
+	c.PrintfLine("220 localhost ESMTP stand-in")
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			c.PrintfLine("250-localhost")
+			c.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			s.mu.Lock()
+			s.auth = arg
+			s.mu.Unlock()
+			c.PrintfLine("235 2.7.0 Authentication successful")
+		case "MAIL":
+			s.mu.Lock()
+			s.from = arg
+			s.mu.Unlock()
+			c.PrintfLine("250 OK")
+		case "RCPT":
+			if s.rejectRcpt != "" && strings.Contains(arg, s.rejectRcpt) {
+				c.PrintfLine("550 5.1.1 No such user")
+				continue
+			}
+
+			s.mu.Lock()
+			s.rcpts = append(s.rcpts, arg)
+			s.mu.Unlock()
+			c.PrintfLine("250 OK")
+		case "DATA":
+			c.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := c.ReadDotBytes()
+			if err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			s.data = string(data)
+			s.mu.Unlock()
+			c.PrintfLine("250 OK")
+		case "QUIT":
+			c.PrintfLine("221 Bye")
+			return
+		default:
+			c.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func TestSMTPSink(t *testing.T) {
+	s := newSMTPServer(t, "")
+
+	sink := NewSMTPSink(model.SMTP{
+		Address:  s.ln.Addr().String(),
+		Username: "alerts@example.com",
+		Password: "secret",
+		To:       "ops@example.com, oncall@example.com",
+	})
+	if err := sink.Send(testAlert()); err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wantAuth := "PLAIN " + base64.StdEncoding.EncodeToString([]byte("\x00alerts@example.com\x00secret"))
+	if s.auth != wantAuth {
+		t.Errorf("AUTH %q, want %q", s.auth, wantAuth)
+	}
+	if s.from != "FROM:<alerts@example.com>" {
+		t.Errorf("MAIL %q, want the username as sender", s.from)
+	}
+	if strings.Join(s.rcpts, " ") != "TO:<ops@example.com> TO:<oncall@example.com>" {
+		t.Errorf("RCPT %q", s.rcpts)
+	}
+
+	for _, want := range []string{
+		"From: alerts@example.com\n",
+		"To: ops@example.com, oncall@example.com\n",
+		"Subject: [dev][cache-middleware-plugin] Get data from memcached error: <timeout>\n",
+		"Date: Tue, 02 Jan 2024 03:04:05 +0000\n",
+		"\nRequestID: req-1\n",
+		"(3 similar alerts suppressed)\n",
+	} {
+		if !strings.Contains(s.data, want) {
+			t.Errorf("message does not contain %q:\n%s", want, s.data)
+		}
+	}
+}
+
+func TestSMTPSinkRejectedRecipient(t *testing.T) {
+	s := newSMTPServer(t, "unknown@example.com")
+
+	sink := NewSMTPSink(model.SMTP{Address: s.ln.Addr().String(), From: "alerts@example.com", To: "unknown@example.com"})
+	if err := sink.Send(testAlert()); err == nil || !strings.Contains(err.Error(), "No such user") {
+		t.Errorf("Send error = %v, want the 550 reply", err)
+	}
+}
@@ -0,0 +1,58 @@
+package log
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ghnexpress/traefik-cache/model"
+)
+
+const defaultTelegramAPIURL = "https://api.telegram.org"
+
+type telegramSink struct {
+	apiURL string
+	chatID string
+	token  string
+	client *http.Client
+}
+
+// NewTelegramSink sends alerts with the Bot API at cfg.APIURL, api.telegram.org by default.
+func NewTelegramSink(cfg model.Telegram) AlertSink {
+	apiURL := strings.TrimSuffix(cfg.APIURL, "/")
+	if apiURL == "" {
+		apiURL = defaultTelegramAPIURL
+	}
+
+	return &telegramSink{
+		apiURL: apiURL,
+		chatID: cfg.ChatID,
+		token:  cfg.Token,
+		client: &http.Client{Timeout: timeoutOrDefault(0)},
+	}
+}
+
+func (s *telegramSink) Name() string {
+	return "telegram"
+}
+
+func (s *telegramSink) Send(a Alert) error {
+	params := url.Values{}
+	params.Add("chat_id", s.chatID)
+	params.Add("text", html.EscapeString(a.Text()))
+	params.Add("parse_mode", "HTML")
+
+	rs, err := s.client.Get(fmt.Sprintf("%s/%s/sendMessage?%s", s.apiURL, s.token, params.Encode()))
+	if err != nil {
+		// The url.Error message would hold the bot token.
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+
+		return err
+	}
+
+	return checkResponse(rs)
+}
@@ -0,0 +1,69 @@
+package log
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghnexpress/traefik-cache/model"
+)
+
+func testAlert() Alert {
+	return Alert{
+		Env:        "dev",
+		RequestID:  "req-1",
+		Message:    "Get data from memcached error: <timeout>",
+		Suppressed: 3,
+		Time:       time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+func TestTelegramSink(t *testing.T) {
+	var got *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer srv.Close()
+
+	sink := NewTelegramSink(model.Telegram{ChatID: "-100", Token: "bot123:abc", APIURL: srv.URL + "/"})
+	if err := sink.Send(testAlert()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.URL.Path != "/bot123:abc/sendMessage" {
+		t.Errorf("path = %q, want /bot123:abc/sendMessage", got.URL.Path)
+	}
+
+	query := got.URL.Query()
+	if query.Get("chat_id") != "-100" || query.Get("parse_mode") != "HTML" {
+		t.Errorf("query = %v", query)
+	}
+	if text := query.Get("text"); !strings.Contains(text, "&lt;timeout&gt;") || !strings.Contains(text, "(3 similar alerts suppressed)") {
+		t.Errorf("text = %q, want the escaped alert", text)
+	}
+}
+
+func TestTelegramSinkError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"ok":false,"description":"Bad Request: chat not found"}`))
+	}))
+
+	sink := NewTelegramSink(model.Telegram{ChatID: "-100", Token: "bot123:abc", APIURL: srv.URL})
+	if err := sink.Send(testAlert()); err == nil || !strings.Contains(err.Error(), "chat not found") {
+		t.Errorf("Send error = %v, want the API error", err)
+	}
+
+	// A failed request must not report the URL, which holds the token.
+	srv.Close()
+	err := sink.Send(testAlert())
+	if err == nil {
+		t.Fatal("Send to a closed server: expected an error")
+	}
+	if strings.Contains(err.Error(), "bot123:abc") {
+		t.Errorf("Send error %q holds the token", err)
+	}
+}
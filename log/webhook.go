@@ -0,0 +1,92 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/ghnexpress/traefik-cache/model"
+)
+
+// defaultWebhookTemplate renders the alert as a flat JSON object.
+const defaultWebhookTemplate = `{"env":{{json .Env}},"requestId":{{json .RequestID}},"message":{{json .Message}},"suppressed":{{.Suppressed}},"time":{{json .Time}}}`
+
+var webhookFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"text": func(a Alert) string {
+		return a.Text()
+	},
+}
+
+// webhookSink posts alerts rendered by a text/template, e.g. to a PagerDuty-style events API.
+type webhookSink struct {
+	url      string
+	template *template.Template
+	headers  http.Header
+	client   *http.Client
+}
+
+// NewWebhookSink parses cfg.Template, executed with the Alert and the json and text functions.
+// cfg.Headers is a comma-separated list of "Name: value" headers added to the request.
+func NewWebhookSink(cfg model.Webhook) (AlertSink, error) {
+	text := cfg.Template
+	if text == "" {
+		text = defaultWebhookTemplate
+	}
+
+	tmpl, err := template.New("webhook").Funcs(webhookFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Parse alert webhook template error: %v", err)
+	}
+
+	headers := make(http.Header)
+	for _, h := range strings.Split(cfg.Headers, ",") {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			continue
+		}
+
+		headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	return &webhookSink{
+		url:      cfg.URL,
+		template: tmpl,
+		headers:  headers,
+		client:   &http.Client{Timeout: timeoutOrDefault(cfg.Timeout)},
+	}, nil
+}
+
+func (s *webhookSink) Name() string {
+	return "webhook"
+}
+
+func (s *webhookSink) Send(a Alert) error {
+	var body bytes.Buffer
+	if err := s.template.Execute(&body, a); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, &body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for name, vals := range s.headers {
+		req.Header[name] = vals
+	}
+
+	rs, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return checkResponse(rs)
+}
@@ -0,0 +1,121 @@
+package log
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ghnexpress/traefik-cache/model"
+)
+
+// webhookServer records the requests it receives.
+func webhookServer(t *testing.T, status int) (*httptest.Server, *http.Header, *[]byte) {
+	header, body := new(http.Header), new([]byte)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*header = r.Header.Clone()
+		*body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, header, body
+}
+
+func TestWebhookSinkDefaultTemplate(t *testing.T) {
+	srv, header, body := webhookServer(t, http.StatusAccepted)
+
+	sink, err := NewWebhookSink(model.Webhook{URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(testAlert()); err != nil {
+		t.Fatal(err)
+	}
+
+	if ct := header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal(*body, &got); err != nil {
+		t.Fatalf("body %s is not JSON: %v", *body, err)
+	}
+
+	want := map[string]any{
+		"env":        "dev",
+		"requestId":  "req-1",
+		"message":    "Get data from memcached error: <timeout>",
+		"suppressed": float64(3),
+		"time":       "2024-01-02T03:04:05Z",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %v, want %v", k, got[k], v)
+		}
+	}
+}
+
+func TestWebhookSinkTemplate(t *testing.T) {
+	srv, header, body := webhookServer(t, http.StatusOK)
+
+	sink, err := NewWebhookSink(model.Webhook{
+		URL:      srv.URL,
+		Template: `{"routing_key":"abc","event_action":"trigger","payload":{"summary":{{json (text .)}},"source":{{json .Env}},"severity":"error"}}`,
+		Headers:  "Authorization: Token token=xyz, X-Source: traefik",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := testAlert()
+	if err := sink.Send(a); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := header.Get("Authorization"); got != "Token token=xyz" {
+		t.Errorf("Authorization = %q, want Token token=xyz", got)
+	}
+	if got := header.Get("X-Source"); got != "traefik" {
+		t.Errorf("X-Source = %q, want traefik", got)
+	}
+
+	var got struct {
+		RoutingKey string `json:"routing_key"`
+		Payload    struct {
+			Summary string `json:"summary"`
+			Source  string `json:"source"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(*body, &got); err != nil {
+		t.Fatalf("body %s is not JSON: %v", *body, err)
+	}
+
+	if got.RoutingKey != "abc" || got.Payload.Summary != a.Text() || got.Payload.Source != "dev" {
+		t.Errorf("rendered body = %s", *body)
+	}
+}
+
+func TestWebhookSinkErrors(t *testing.T) {
+	if _, err := NewWebhookSink(model.Webhook{URL: "http://localhost", Template: "{{.Missing"}); err == nil {
+		t.Error("NewWebhookSink with an invalid template: expected an error")
+	}
+
+	sink, err := NewWebhookSink(model.Webhook{URL: "http://localhost", Template: "{{.Missing}}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(testAlert()); err == nil {
+		t.Error("Send with a template failing to execute: expected an error")
+	}
+
+	srv, _, _ := webhookServer(t, http.StatusInternalServerError)
+	sink, err = NewWebhookSink(model.Webhook{URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(testAlert()); err == nil {
+		t.Error("Send to a failing endpoint: expected an error")
+	}
+}
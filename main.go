@@ -14,15 +14,16 @@ import (
 	"github.com/ghnexpress/traefik-cache/log"
 	"github.com/ghnexpress/traefik-cache/model"
 	"github.com/ghnexpress/traefik-cache/repo"
+	"github.com/ghnexpress/traefik-cache/tracing"
 	"github.com/ghnexpress/traefik-cache/utils"
-	"github.com/pquerna/cachecontrol"
+	"github.com/pquerna/cachecontrol/cacheobject"
 )
 
 var (
-	cacheRepo           repo.Repository
-	onceMemcached       = make(map[string]*sync.Once)
-	onceMemcachedMutex  = sync.RWMutex{}
+	cacheRepos          = make(map[string]repo.Repository)
+	cacheReposMutex     = sync.Mutex{}
 	defaultForceExpired = 60 * 60
+	defaultMaxBodySize  = 10 * 1024 * 1024
 	ignoreHeaderFields  = []string{"X-Request-Id", "Postman-Token", "Content-Length"}
 )
 
@@ -37,48 +38,97 @@ func CreateConfig() *model.Config {
 	return &model.Config{
 		Memcached: model.MemcachedConfig{},
 		HashKey:   model.HashKey{Method: model.Enable{Enable: true}},
+		Compress:  model.Compress{Enable: true},
 		Env:       MASTER_ENV,
 	}
 }
 
 type Cache struct {
-	name      string
-	next      http.Handler
-	log       log.Log
-	config    model.Config
-	cacheRepo repo.Repository
+	name        string
+	next        http.Handler
+	log         log.Log
+	config      model.Config
+	cacheRepo   repo.Repository
+	coalescer   *coalescer
+	tracer      *tracing.Tracer
+	bans        *banCache
+	unsafeBans  *unsafeBanCache
+	methods     []string
+	statusRules []statusRule
+	unsafeRules []unsafeRule
 }
 
 func New(_ context.Context, next http.Handler, config *model.Config, name string) (http.Handler, error) {
-	log := log.New(config.Env, config.Alert.Telegram)
-	log.ConsoleLog("config", config)
+	logger, err := log.New(*config)
+	if err != nil {
+		return nil, err
+	}
+	logger = logger.With(log.Fields{"router": name})
+	logger.Info("Cache middleware configured", log.Fields{"config": config})
 
-	onceMemcachedMutex.Lock()
-	onceKey := fmt.Sprintf("%+v", config.Memcached)
-	if onceMemcached[onceKey] == nil {
-		onceMemcached[onceKey] = &sync.Once{}
+	statusRules, err := parseStatusRules(config.Cacheable.Status)
+	if err != nil {
+		return nil, err
 	}
 
-	onceMemcached[onceKey].Do(func() {
-		cacheRepo = repo.NewRepoManager(config.Memcached)
-	})
-	onceMemcachedMutex.Unlock()
+	cacheRepo, err := getRepo(config, logger)
+	if err != nil {
+		return nil, err
+	}
 
 	return &Cache{
-		name:      name,
-		next:      next,
-		log:       log,
-		config:    *config,
-		cacheRepo: cacheRepo,
+		name:        name,
+		next:        next,
+		log:         logger,
+		config:      *config,
+		cacheRepo:   instrumentedRepo{Repository: cacheRepo, router: name},
+		coalescer:   newCoalescer(),
+		bans:        newBanCache(),
+		unsafeBans:  newUnsafeBanCache(),
+		tracer:      getTracer(config.Tracing, logger),
+		methods:     parseMethods(config.Cacheable.Methods),
+		statusRules: statusRules,
+		unsafeRules: parseUnsafeRules(config.InvalidateOnUnsafe.Rules),
 	}, nil
 }
 
+// getRepo shares one Repository between all the middlewares using the same storage configuration.
+func getRepo(config *model.Config, logger log.Log) (repo.Repository, error) {
+	cacheReposMutex.Lock()
+	defer cacheReposMutex.Unlock()
+
+	repoKey := fmt.Sprintf("%+v|%+v", config.Storage, config.Memcached)
+	if cacheRepo, ok := cacheRepos[repoKey]; ok {
+		return cacheRepo, nil
+	}
+
+	cacheRepo, err := repo.New(*config)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheRepos[repoKey] = cacheRepo
+
+	storageType := config.Storage.Type
+	if storageType == "" {
+		storageType = string(constants.MemcachedStorageType)
+	}
+	logger.Info("Storage ready", log.Fields{"storage": storageType})
+
+	return cacheRepo, nil
+}
+
 func (c *Cache) key(r *http.Request) (string, error) {
 	hashKey := c.config.HashKey
 
 	hMethod := ""
 	if hashKey.Method.Enable {
 		hMethod = r.Method
+
+		// HEAD is answered from the GET entry.
+		if r.Method == http.MethodHead {
+			hMethod = http.MethodGet
+		}
 	}
 
 	hHeader := ""
@@ -123,91 +173,411 @@ func (c *Cache) key(r *http.Request) (string, error) {
 func (c *Cache) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	requestID := req.Header.Get(X_REQUEST_ID_HEADER)
 
-	key, err := c.key(req)
-	if err != nil {
-		c.log.TelegramLog(requestID, fmt.Errorf("Build key memcached error: %v", err))
+	if c.config.Metrics.Enable && req.URL.Path == c.metricsPath() {
+		c.serveMetrics(rw, req)
+		return
+	}
+
+	start := time.Now()
+	req, d := c.startDecision(req)
+	defer func() {
+		c.observeStatus(d.cacheStatus())
+		c.logDecision(req, requestID, d, time.Since(start))
+	}()
+
+	req, span := c.startTrace(req)
+	if span != nil {
+		defer func() {
+			span.SetAttributes(tracing.String("cache.status", string(d.cacheStatus())))
+			span.End()
+		}()
+	}
 
-		rw.Header().Set(CACHE_HEADER, string(constants.ErrorCacheStatus))
+	if c.config.Purge.Enable && req.URL.Path == c.purgePath() {
+		c.servePurge(rw, req)
+		return
+	}
+
+	if c.isInvalidation(req) {
+		c.serveInvalidation(rw, req)
+		return
+	}
 
-		c.next.ServeHTTP(rw, req)
+	if c.config.InvalidateOnUnsafe.Enable && isUnsafe(req.Method) {
+		c.serveUnsafe(rw, req, requestID)
+		return
+	}
 
+	if !c.methodCacheable(req.Method) {
+		d.addReason("method not cacheable")
+		c.passThrough(rw, req, "", cacheStatus{status: constants.BypassCacheStatus, fwd: fwdMethod})
 		return
 	}
 
-	value, err := c.cacheRepo.Get(key)
+	keySpan := c.startSpan(req, "cache.key", tracing.KindInternal)
+	key, err := c.key(req)
+	keySpan.SetError(err)
+	keySpan.End()
+	d.update(func(d *decision) { d.key = key })
 	if err != nil {
-		c.log.TelegramLog(requestID, err)
+		c.log.Alert(requestID, fmt.Errorf("Build key memcached error: %v", err))
 
-		rw.Header().Set(CACHE_HEADER, string(constants.ErrorCacheStatus))
+		c.passThrough(rw, req, "", cacheStatus{status: constants.ErrorCacheStatus, fwd: fwdMiss, detail: "key error"})
 
-		c.next.ServeHTTP(rw, req)
+		return
+	}
+
+	span.SetAttributes(tracing.String("cache.key", key))
+
+	lookupStart := time.Now()
+	getSpan := c.startSpan(req, "cache.get", tracing.KindClient)
+	value, vary, err := c.lookup(req, key)
+	getSpan.SetAttributes(tracing.String("cache.key", key), tracing.Bool("cache.found", value != nil))
+	getSpan.SetError(err)
+	getSpan.End()
+	d.update(func(d *decision) {
+		d.lookupDuration = time.Since(lookupStart)
+		d.backendErr = err
+		switch {
+		case err != nil:
+			d.lookup = "error"
+		case value != nil:
+			d.lookup = "found"
+		default:
+			d.lookup = "miss"
+		}
+	})
+	if err != nil {
+		c.log.Alert(requestID, err)
+
+		c.passThrough(rw, req, key, cacheStatus{status: constants.ErrorCacheStatus, fwd: fwdMiss, detail: "storage error"})
 
 		return
 	}
 
+	if value != nil && c.banned(req, requestID, value) {
+		d.update(func(d *decision) { d.lookup = "banned" })
+		value = nil
+	}
+
 	if value != nil {
-		for key, vals := range value.Headers {
-			for _, val := range vals {
-				rw.Header().Add(key, val)
-			}
+		now := time.Now()
+
+		switch {
+		case value.IsFresh(now):
+			c.serveCache(rw, req, requestID, key, value, hitStatus(value))
+			return
+		case value.CanStaleWhileRevalidate(now):
+			c.serveCache(rw, req, requestID, key, value, staleStatus(value))
+			c.revalidate(req, requestID, key, value)
+			return
+		case value.CanStaleIfError(now) || value.HasValidators():
+			stale := value
+			c.coalesce(rw, req, requestID, key, vary, fwdStale, func() *model.Cache {
+				return c.fetchStale(rw, req, requestID, key, stale)
+			})
+			return
 		}
+	}
 
-		rw.Header().Set(CACHE_HEADER, string(constants.HitCacheStatus))
-		if c.config.Env == DEV_ENV {
-			rw.Header().Set("debug-cache-traefik", fmt.Sprintf("time: %s, key: %s", time.Now().Format(time.RFC3339), key))
-		}
+	// An entry left at this point is stale and cannot be served.
+	fwd := fwdURIMiss
+	switch {
+	case value != nil:
+		fwd = fwdStale
+	case len(vary) > 0:
+		fwd = fwdVaryMiss
+	}
 
-		rw.WriteHeader(value.Status)
-		if _, err := rw.Write(value.Body); err != nil {
-			c.log.TelegramLog(requestID, fmt.Errorf("Write data from cache to response body error: %v", err))
+	// The response to a HEAD request is not stored: there is nothing to share.
+	if req.Method == http.MethodHead {
+		c.fetch(rw, req, requestID, key, fwd)
+		return
+	}
+
+	c.coalesce(rw, req, requestID, key, vary, fwd, func() *model.Cache {
+		return c.fetch(rw, req, requestID, key, fwd)
+	})
+}
+
+// coalesce calls fetch once at a time per variant: concurrent requests wait for the leader
+// and are served the value it stored.
+func (c *Cache) coalesce(rw http.ResponseWriter, req *http.Request, requestID, key string, vary []string, fwd string, fetch func() *model.Cache) {
+	if !c.config.Coalesce.Enable {
+		fetch()
+		return
+	}
+
+	flight := variantKey(key, vary, req)
+	cl, leader := c.coalescer.join(flight)
+	if leader {
+		coalescedInFlight.Inc(c.name, "leader")
+		defer coalescedInFlight.Dec(c.name, "leader")
+
+		var value *model.Cache
+		defer func() { c.coalescer.finish(flight, cl, value) }()
+
+		value = fetch()
+		return
+	}
+
+	maxWait := c.config.Coalesce.MaxWait
+	if maxWait <= 0 {
+		maxWait = defaultCoalesceMaxWait
+	}
+
+	// The leader failed, timed out or got an uncacheable response: go upstream ourselves.
+	// A response varying on headers the waiter was not keyed on may be another variant.
+	coalescedInFlight.Inc(c.name, "waiter")
+	value := cl.wait(time.Duration(maxWait) * time.Second)
+	coalescedInFlight.Dec(c.name, "waiter")
+	if value != nil && (len(vary) > 0 || len(variantNames(value.Headers)) == 0) {
+		c.serveCache(rw, req, requestID, key, value, cacheStatus{status: constants.HitCacheStatus, fwd: fwd, value: value, collapsed: true})
+		return
+	}
+
+	fetch()
+}
 
-			if err := c.cacheRepo.Delete(key); err != nil {
-				c.log.TelegramLog(requestID, err)
+func (c *Cache) serveCache(rw http.ResponseWriter, req *http.Request, requestID, key string, value *model.Cache, st cacheStatus) {
+	for key, vals := range value.Headers {
+		for _, val := range vals {
+			if key == "Vary" {
+				addVary(rw.Header(), val)
+				continue
 			}
+
+			rw.Header().Add(key, val)
 		}
+	}
+
+	c.setCacheStatus(rw.Header(), req, key, st)
+	if c.config.Env == DEV_ENV {
+		rw.Header().Set("debug-cache-traefik", fmt.Sprintf("time: %s, key: %s", time.Now().Format(time.RFC3339), key))
+	}
+
+	if notModified(req, value) {
+		for _, k := range representationHeaders {
+			rw.Header().Del(k)
+		}
+
+		rw.WriteHeader(http.StatusNotModified)
 		return
 	}
 
-	rw.Header().Set(CACHE_HEADER, string(constants.MissCacheStatus))
-	checkCompress := rw.Header().Get("Vary")
+	if c.serveRange(rw, req, value) {
+		return
+	}
 
-	r := &ResponseWriter{ResponseWriter: rw}
+	body := c.encodeBody(req, rw.Header(), value.Body)
+	if value.Status == http.StatusOK {
+		rw.Header().Set("Accept-Ranges", "bytes")
+	}
 
-	c.next.ServeHTTP(r, req)
+	rw.WriteHeader(value.Status)
+	if req.Method == http.MethodHead {
+		return
+	}
 
-	force := c.config.ForceCache
-	ok := force.Enable
-	expiredTime := time.Now().Add(time.Second * time.Duration(force.ExpiredTime))
-	if ok && force.ExpiredTime <= 0 {
-		expiredTime = time.Now().Add(time.Second * time.Duration(defaultForceExpired))
+	if _, err := rw.Write(body); err != nil {
+		c.log.Alert(requestID, fmt.Errorf("Write data from cache to response body error: %v", err))
+
+		if err := c.cacheRepo.Delete(key); err != nil {
+			c.log.Alert(requestID, err)
+		}
 	}
+}
+
+// fetch calls the upstream, fwd being the reason why, and stores its response when
+// cacheable. It returns the stored value, nil when the response was not cacheable.
+func (c *Cache) fetch(rw http.ResponseWriter, req *http.Request, requestID, key, fwd string) *model.Cache {
+	r := newResponseWriter(rw)
+	r.maxBodySize = c.maxBodySize()
+	r.beforeWriteHeader = func(status int) {
+		st := cacheStatus{status: constants.MissCacheStatus, fwd: fwd}
+
+		switch {
+		case !c.statusCacheable(status):
+			st.status = constants.BypassCacheStatus
+		case contentLength(r.Header()) > r.maxBodySize:
+			// Known to be too large: do not even start capturing.
+			r.truncated = true
+			st.status = constants.BypassCacheStatus
+		default:
+			st.stored = c.storable(req, status, r.Header())
+		}
+
+		c.setCacheStatus(rw.Header(), req, key, st)
+	}
+
+	upstreamReq := req
+	if req.Header.Get("Accept-Encoding") != "" {
+		upstreamReq = req.Clone(req.Context())
+		restrictAcceptEncoding(upstreamReq.Header)
+	}
+
+	c.upstream(r, upstreamReq)
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+
+	// The upstream answered the client's own preconditions, or a HEAD request without the
+	// body of the GET entry: there is no response to store.
+	switch {
+	case r.status == http.StatusNotModified:
+		decisionOf(req).addReason("not modified response")
+		return nil
+	case req.Method == http.MethodHead:
+		decisionOf(req).addReason("HEAD response")
+		return nil
+	case r.truncated:
+		decisionOf(req).addReason("body larger than maxBodySize")
+		return nil
+	}
+
+	return c.store(req, requestID, key, r.status, r.Header().Clone(), r.body)
+}
+
+// store saves the response when cacheable, keeping it past its freshness for the stale
+// grace windows. It returns the stored value, nil when the response was not cacheable.
+func (c *Cache) store(req *http.Request, requestID, key string, status int, header http.Header, body []byte) *model.Cache {
+	// A partial response is not the representation a later request expects.
+	if status == http.StatusPartialContent {
+		decisionOf(req).addReason("partial content")
+		return nil
+	}
+
+	expiredTime, ok := c.expiration(req, status, header)
 	if !ok {
-		expiredTime, ok = c.cacheable(req, rw, r.status)
+		return nil
+	}
+
+	if containsName(varyNames(header), "*") {
+		decisionOf(req).addReason("Vary: *")
+		return nil
 	}
 
-	if ok {
-		// Router --> Compress Middleware --> Cache Middleware --> Service
-		if checkCompress != "" {
-			r.Header().Del("Content-Encoding")
-			r.Header().Del("Vary")
+	// One identity representation is stored for every Accept-Encoding, see encodeBody.
+	vary := variantNames(header)
+	body, ok = decodeBody(header, body, c.maxBodySize())
+	if !ok || len(body) > c.maxBodySize() {
+		decisionOf(req).addReason("body not decodable or larger than maxBodySize")
+		return nil
+	}
+
+	whileRevalidate, ifError := c.staleWindows(header)
+	value := &model.Cache{
+		Status:               status,
+		Headers:              header,
+		Body:                 body,
+		Expires:              expiredTime.Unix(),
+		StaleWhileRevalidate: whileRevalidate,
+		StaleIfError:         ifError,
+		ETag:                 header.Get("ETag"),
+		LastModified:         header.Get("Last-Modified"),
+		StoredAt:             time.Now().UnixNano(),
+		URL:                  req.URL.RequestURI(),
+		Tags:                 surrogateKeys(header),
+	}
+
+	grace := whileRevalidate
+	if ifError > grace {
+		grace = ifError
+	}
+
+	// Entries carrying a validator are kept longer to be revalidated instead of re-fetched.
+	if keep := int64(c.config.Stale.Keep); value.HasValidators() && keep > grace {
+		grace = keep
+	}
+
+	storedUntil := expiredTime.Add(time.Duration(grace) * time.Second)
+
+	setStart := time.Now()
+	setSpan := c.startSpan(req, "cache.set", tracing.KindClient)
+	defer setSpan.End()
+	setSpan.SetAttributes(
+		tracing.String("cache.key", key),
+		tracing.Int("cache.body_size", len(body)),
+		tracing.Int("cache.ttl", int(time.Until(storedUntil).Seconds())),
+	)
+
+	if len(vary) > 0 {
+		if err := c.cacheRepo.SetExpires(key, storedUntil, model.Cache{VaryIndex: vary}); err != nil {
+			setSpan.SetError(err)
+			c.log.Alert(requestID, err)
 		}
+	}
 
-		err = c.cacheRepo.SetExpires(key, expiredTime, model.Cache{
-			Status:  r.status,
-			Headers: r.Header(),
-			Body:    r.body,
+	if err := c.cacheRepo.SetExpires(variantKey(key, vary, req), storedUntil, *value); err != nil {
+		setSpan.SetError(err)
+		c.log.Alert(requestID, err)
+	} else {
+		storedBodySize.Observe(float64(len(body)), c.name)
+		decisionOf(req).update(func(d *decision) {
+			d.stored = true
+			d.ttl = time.Until(expiredTime)
+			d.grace = time.Duration(grace) * time.Second
+			d.size = len(body)
+			d.storeDuration = time.Since(setStart)
 		})
+	}
 
-		if err != nil {
-			c.log.TelegramLog(requestID, err)
+	return value
+}
+
+func (c *Cache) maxBodySize() int {
+	if c.config.MaxBodySize > 0 {
+		return c.config.MaxBodySize
+	}
+
+	return defaultMaxBodySize
+}
+
+func (c *Cache) expiration(req *http.Request, status int, header http.Header) (time.Time, bool) {
+	if !c.statusCacheable(status) {
+		decisionOf(req).addReason(fmt.Sprintf("status %d not cacheable", status))
+		return time.Time{}, false
+	}
+
+	force := c.config.ForceCache
+	if !force.Enable {
+		expires, ok := c.cacheable(req, status, header)
+
+		// The headers tell whether the response may be stored, the rule for how long.
+		if rule, found := c.statusRule(status); ok && found {
+			return time.Now().Add(rule.ttl), true
 		}
+
+		return expires, ok
+	}
+
+	if rule, ok := c.statusRule(status); ok {
+		return time.Now().Add(rule.ttl), true
+	}
+
+	if force.ExpiredTime <= 0 {
+		return time.Now().Add(time.Second * time.Duration(defaultForceExpired)), true
 	}
+
+	return time.Now().Add(time.Second * time.Duration(force.ExpiredTime)), true
 }
 
-func (c *Cache) cacheable(req *http.Request, rw http.ResponseWriter, status int) (time.Time, bool) {
-	reasons, expiredTime, err := cachecontrol.CachableResponseWriter(req, status, rw, cachecontrol.Options{})
+func (c *Cache) cacheable(req *http.Request, status int, header http.Header) (time.Time, bool) {
+	reasons, expiredTime, err := cacheobject.UsingRequestResponse(req, status, header, false)
 
-	if err != nil || len(reasons) > 0 || expiredTime.Before(time.Now()) {
+	d := decisionOf(req)
+	for _, reason := range reasons {
+		d.addReason(strings.TrimPrefix(reason.String(), "Reason"))
+	}
+
+	switch {
+	case err != nil:
+		d.addReason(fmt.Sprintf("cache-control error: %v", err))
+		return time.Time{}, false
+	case len(reasons) > 0:
+		return time.Time{}, false
+	case expiredTime.Before(time.Now()):
+		d.addReason("expired")
 		return time.Time{}, false
 	}
 
@@ -0,0 +1,144 @@
+package traefik_cache
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ghnexpress/traefik-cache/constants"
+	"github.com/ghnexpress/traefik-cache/metrics"
+	"github.com/ghnexpress/traefik-cache/model"
+	"github.com/ghnexpress/traefik-cache/repo"
+)
+
+const defaultMetricsPath = "/_cache/metrics"
+
+// The registry is shared by every middleware instance, series being labelled by router name.
+var (
+	metricsRegistry = metrics.NewRegistry()
+
+	cacheRequests = metricsRegistry.NewCounterVec("traefik_cache_requests_total",
+		"Requests handled by the cache middleware, by router and cache status.", "router", "status")
+	backendDuration = metricsRegistry.NewHistogramVec("traefik_cache_backend_duration_seconds",
+		"Latency of the storage backend operations.",
+		[]float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}, "router", "operation")
+	backendErrors = metricsRegistry.NewCounterVec("traefik_cache_backend_errors_total",
+		"Failed storage backend operations.", "router", "operation")
+	storedBodySize = metricsRegistry.NewHistogramVec("traefik_cache_stored_body_bytes",
+		"Size of the bodies stored in the cache.",
+		[]float64{1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20}, "router")
+	coalescedInFlight = metricsRegistry.NewGaugeVec("traefik_cache_coalesced_inflight",
+		"Coalesced requests in flight, leaders calling the upstream and waiters blocked on them.", "router", "role")
+)
+
+func init() {
+	metricsRegistry.NewCounterFunc("traefik_cache_storage_raw_bytes_total",
+		"Payload bytes written to the storage backend before compression.", func() float64 {
+			raw, _ := repo.CompressionStats()
+			return float64(raw)
+		})
+	metricsRegistry.NewCounterFunc("traefik_cache_storage_stored_bytes_total",
+		"Payload bytes written to the storage backend after compression.", func() float64 {
+			_, stored := repo.CompressionStats()
+			return float64(stored)
+		})
+	metricsRegistry.NewGaugeFunc("traefik_cache_storage_compression_saved_ratio",
+		"Share of the payload bytes saved by compression.", func() float64 {
+			raw, stored := repo.CompressionStats()
+			if raw == 0 {
+				return 0
+			}
+
+			return 1 - float64(stored)/float64(raw)
+		})
+}
+
+func (c *Cache) metricsPath() string {
+	if c.config.Metrics.Path != "" {
+		return c.config.Metrics.Path
+	}
+
+	return defaultMetricsPath
+}
+
+// serveMetrics writes the registry in the Prometheus text format, to the IPs of
+// metrics.allowIps only when set.
+func (c *Cache) serveMetrics(rw http.ResponseWriter, req *http.Request) {
+	if allowIPs := c.config.Metrics.AllowIPs; allowIPs != "" {
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			host = req.RemoteAddr
+		}
+
+		if ip := net.ParseIP(host); ip == nil || !ipAllowed(ip, allowIPs) {
+			http.Error(rw, "forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		rw.Header().Set("Allow", "GET, HEAD")
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rw.Header().Set("Content-Type", metrics.ContentType)
+	rw.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodHead {
+		return
+	}
+
+	metricsRegistry.WriteTo(rw)
+}
+
+// observeStatus counts the request by the cache status it was answered with, if any.
+func (c *Cache) observeStatus(status constants.CacheStatus) {
+	if status != "" {
+		cacheRequests.Inc(c.name, string(status))
+	}
+}
+
+// instrumentedRepo records the latency and errors of the backend operations of a router.
+type instrumentedRepo struct {
+	repo.Repository
+	router string
+}
+
+func (r instrumentedRepo) Get(key string) (*model.Cache, error) {
+	start := time.Now()
+	value, err := r.Repository.Get(key)
+	r.observe("get", start, err)
+
+	return value, err
+}
+
+func (r instrumentedRepo) SetExpires(key string, t time.Time, data model.Cache) error {
+	start := time.Now()
+	err := r.Repository.SetExpires(key, t, data)
+	r.observe("set", start, err)
+
+	return err
+}
+
+func (r instrumentedRepo) Delete(key string) error {
+	start := time.Now()
+	err := r.Repository.Delete(key)
+	r.observe("delete", start, err)
+
+	return err
+}
+
+func (r instrumentedRepo) Increment(key string, delta uint64, t time.Time) (uint64, error) {
+	start := time.Now()
+	value, err := r.Repository.Increment(key, delta, t)
+	r.observe("increment", start, err)
+
+	return value, err
+}
+
+func (r instrumentedRepo) observe(operation string, start time.Time, err error) {
+	backendDuration.Observe(time.Since(start).Seconds(), r.router, operation)
+	if err != nil {
+		backendErrors.Inc(r.router, operation)
+	}
+}
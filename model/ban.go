@@ -0,0 +1,9 @@
+package model
+
+// Ban invalidates every entry of a host stored before CreatedAt, in unix nanoseconds, and
+// matching Type and Value.
+type Ban struct {
+	Type      string `json:"type"`
+	Value     string `json:"value"`
+	CreatedAt int64  `json:"createdAt"`
+}
@@ -1,7 +1,44 @@
 package model
 
+import "time"
+
 type Cache struct {
 	Status  int
 	Headers map[string][]string
 	Body    []byte
+	// Expires is the unix time the entry stops being fresh. Entries stored without it
+	// are fresh until the backend evicts them.
+	Expires int64
+	// StaleWhileRevalidate and StaleIfError are the grace windows after Expires, in seconds.
+	StaleWhileRevalidate int64
+	StaleIfError         int64
+	// ETag and LastModified are the validators used to revalidate a stale entry.
+	ETag         string
+	LastModified string
+	// StoredAt is the unix time in nanoseconds the entry was stored or last revalidated,
+	// compared with the time bans were created.
+	StoredAt int64
+	// VaryIndex is set on the entry stored under the primary key of a response with a
+	// Vary header: it lists the request headers selecting the variant's secondary key.
+	VaryIndex []string
+	// URL is the request URI the entry was stored for and Tags its surrogate keys, both
+	// matched against the bans of the host.
+	URL  string
+	Tags []string
+}
+
+func (c *Cache) IsFresh(now time.Time) bool {
+	return c.Expires == 0 || now.Unix() < c.Expires
+}
+
+func (c *Cache) CanStaleWhileRevalidate(now time.Time) bool {
+	return now.Unix() < c.Expires+c.StaleWhileRevalidate
+}
+
+func (c *Cache) CanStaleIfError(now time.Time) bool {
+	return now.Unix() < c.Expires+c.StaleIfError
+}
+
+func (c *Cache) HasValidators() bool {
+	return c.ETag != "" || c.LastModified != ""
 }
@@ -4,6 +4,33 @@ type MemcachedConfig struct {
 	Address           string `json:"address,omitempty"`
 	Timeout           int    `json:"timeout,omitempty"`
 	MaxIdleConnection int    `json:"maxIdleConnection,omitempty"`
+	ChunkSize         int    `json:"chunkSize,omitempty"`
+}
+
+type MemoryConfig struct {
+	MaxSize    int `json:"maxSize,omitempty"`
+	MaxEntries int `json:"maxEntries,omitempty"`
+}
+
+type RedisConfig struct {
+	Address           string `json:"address,omitempty"`
+	Username          string `json:"username,omitempty"`
+	Password          string `json:"password,omitempty"`
+	DB                int    `json:"db,omitempty"`
+	Timeout           int    `json:"timeout,omitempty"`
+	MaxIdleConnection int    `json:"maxIdleConnection,omitempty"`
+}
+
+type StorageCompression struct {
+	Enable  bool `json:"enable,omitempty"`
+	MinSize int  `json:"minSize,omitempty"`
+}
+
+type StorageConfig struct {
+	Type        string             `json:"type,omitempty"`
+	Memory      MemoryConfig       `json:"memory,omitempty"`
+	Redis       RedisConfig        `json:"redis,omitempty"`
+	Compression StorageCompression `json:"compression,omitempty"`
 }
 
 type Enable struct {
@@ -25,10 +52,51 @@ type HashKey struct {
 type Telegram struct {
 	ChatID string `json:"chatId,omitempty"`
 	Token  string `json:"token,omitempty"`
+	APIURL string `json:"apiUrl,omitempty"`
+}
+
+type Slack struct {
+	WebhookURL string `json:"webhookUrl,omitempty"`
+}
+
+type Webhook struct {
+	URL      string `json:"url,omitempty"`
+	Template string `json:"template,omitempty"`
+	Headers  string `json:"headers,omitempty"`
+	Timeout  int    `json:"timeout,omitempty"`
+}
+
+type SMTP struct {
+	Address  string `json:"address,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+	Timeout  int    `json:"timeout,omitempty"`
+}
+
+type LogConfig struct {
+	Level  string `json:"level,omitempty"`
+	Format string `json:"format,omitempty"`
+}
+
+type CacheStatus struct {
+	Legacy bool `json:"legacy,omitempty"`
+}
+
+type AccessLog struct {
+	Enable      bool `json:"enable,omitempty"`
+	SampleRate  int  `json:"sampleRate,omitempty"`
+	OnlyNonHits bool `json:"onlyNonHits,omitempty"`
 }
 
 type AlertConfig struct {
-	Telegram Telegram `json:"telegram,omitempty"`
+	Telegram  Telegram `json:"telegram,omitempty"`
+	Slack     Slack    `json:"slack,omitempty"`
+	Webhook   Webhook  `json:"webhook,omitempty"`
+	SMTP      SMTP     `json:"smtp,omitempty"`
+	Interval  int      `json:"interval,omitempty"`
+	QueueSize int      `json:"queueSize,omitempty"`
 }
 
 type ForceCache struct {
@@ -36,10 +104,80 @@ type ForceCache struct {
 	ExpiredTime int  `json:"expiredTime,omitempty"`
 }
 
+type Coalesce struct {
+	Enable  bool `json:"enable,omitempty"`
+	MaxWait int  `json:"maxWait,omitempty"`
+}
+
+type Stale struct {
+	WhileRevalidate int `json:"whileRevalidate,omitempty"`
+	IfError         int `json:"ifError,omitempty"`
+	Timeout         int `json:"timeout,omitempty"`
+	Keep            int `json:"keep,omitempty"`
+}
+
+type Compress struct {
+	Enable  bool `json:"enable,omitempty"`
+	MinSize int  `json:"minSize,omitempty"`
+}
+
+type Metrics struct {
+	Enable   bool   `json:"enable,omitempty"`
+	Path     string `json:"path,omitempty"`
+	AllowIPs string `json:"allowIps,omitempty"`
+}
+
+type Tracing struct {
+	Endpoint    string `json:"endpoint,omitempty"`
+	ServiceName string `json:"serviceName,omitempty"`
+	SampleRate  int    `json:"sampleRate,omitempty"`
+	Timeout     int    `json:"timeout,omitempty"`
+}
+
+type Purge struct {
+	Enable    bool   `json:"enable,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Token     string `json:"token,omitempty"`
+	Retention int    `json:"retention,omitempty"`
+}
+
+type Invalidation struct {
+	Enable       bool   `json:"enable,omitempty"`
+	PurgeMethod  string `json:"purgeMethod,omitempty"`
+	BanMethod    string `json:"banMethod,omitempty"`
+	AllowIPs     string `json:"allowIps,omitempty"`
+	Secret       string `json:"secret,omitempty"`
+	SecretHeader string `json:"secretHeader,omitempty"`
+}
+
+type InvalidateOnUnsafe struct {
+	Enable bool   `json:"enable,omitempty"`
+	Rules  string `json:"rules,omitempty"`
+}
+
+type Cacheable struct {
+	Methods string `json:"methods,omitempty"`
+	Status  string `json:"status,omitempty"`
+}
+
 type Config struct {
-	Memcached  MemcachedConfig `json:"memcached,omitempty"`
-	HashKey    HashKey         `json:"hashkey,omitempty"`
-	Alert      AlertConfig     `json:"alert,omitempty"`
-	ForceCache ForceCache      `json:"forceCache,omitempty"`
-	Env        string          `json:"env,omitempty"`
+	Storage            StorageConfig      `json:"storage,omitempty"`
+	Memcached          MemcachedConfig    `json:"memcached,omitempty"`
+	HashKey            HashKey            `json:"hashkey,omitempty"`
+	Alert              AlertConfig        `json:"alert,omitempty"`
+	Log                LogConfig          `json:"log,omitempty"`
+	AccessLog          AccessLog          `json:"accessLog,omitempty"`
+	CacheStatus        CacheStatus        `json:"cacheStatus,omitempty"`
+	ForceCache         ForceCache         `json:"forceCache,omitempty"`
+	Cacheable          Cacheable          `json:"cacheable,omitempty"`
+	Coalesce           Coalesce           `json:"coalesce,omitempty"`
+	Stale              Stale              `json:"stale,omitempty"`
+	Compress           Compress           `json:"compress,omitempty"`
+	Purge              Purge              `json:"purge,omitempty"`
+	Invalidation       Invalidation       `json:"invalidation,omitempty"`
+	InvalidateOnUnsafe InvalidateOnUnsafe `json:"invalidateOnUnsafe,omitempty"`
+	Metrics            Metrics            `json:"metrics,omitempty"`
+	Tracing            Tracing            `json:"tracing,omitempty"`
+	MaxBodySize        int                `json:"maxBodySize,omitempty"`
+	Env                string             `json:"env,omitempty"`
 }
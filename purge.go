@@ -0,0 +1,135 @@
+package traefik_cache
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ghnexpress/traefik-cache/constants"
+	"github.com/ghnexpress/traefik-cache/model"
+)
+
+const defaultPurgePath = "/_cache/purge"
+
+type purgeRequest struct {
+	Host     string   `json:"host,omitempty"`
+	URLs     []string `json:"urls,omitempty"`
+	Prefixes []string `json:"prefixes,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+}
+
+func (c *Cache) purgePath() string {
+	if c.config.Purge.Path != "" {
+		return c.config.Purge.Path
+	}
+
+	return defaultPurgePath
+}
+
+// servePurge registers the bans sent to the admin endpoint, either as a JSON purgeRequest
+// body or as host, url, prefix and tag query parameters.
+func (c *Cache) servePurge(rw http.ResponseWriter, req *http.Request) {
+	requestID := req.Header.Get(X_REQUEST_ID_HEADER)
+
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if c.config.Purge.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(c.config.Purge.Token)) != 1 {
+		writeJSON(rw, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	if req.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		writeJSON(rw, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	var pr purgeRequest
+	if req.ContentLength != 0 && strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(req.Body).Decode(&pr); err != nil {
+			writeJSON(rw, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid body: %v", err)})
+			return
+		}
+	}
+
+	query := req.URL.Query()
+	if pr.Host == "" {
+		pr.Host = query.Get("host")
+	}
+	pr.URLs = append(pr.URLs, query["url"]...)
+	pr.Prefixes = append(pr.Prefixes, query["prefix"]...)
+	pr.Tags = append(pr.Tags, query["tag"]...)
+
+	if pr.Host == "" {
+		pr.Host = req.Host
+	}
+
+	bans := make(map[string][]model.Ban)
+	now := time.Now().UnixNano()
+	add := func(host string, banType constants.BanType, value string) {
+		bans[host] = append(bans[host], model.Ban{Type: string(banType), Value: value, CreatedAt: now})
+	}
+
+	for _, raw := range pr.URLs {
+		host, uri, err := splitURL(pr.Host, raw)
+		if err != nil {
+			writeJSON(rw, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		add(host, constants.URLBanType, uri)
+	}
+
+	for _, raw := range pr.Prefixes {
+		host, uri, err := splitURL(pr.Host, raw)
+		if err != nil {
+			writeJSON(rw, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		add(host, constants.PrefixBanType, uri)
+	}
+
+	for _, tag := range pr.Tags {
+		add(pr.Host, constants.TagBanType, tag)
+	}
+
+	if len(bans) == 0 {
+		writeJSON(rw, http.StatusBadRequest, map[string]string{"error": "nothing to purge"})
+		return
+	}
+
+	registered := 0
+	for host, hostBans := range bans {
+		if err := c.addBans(host, hostBans...); err != nil {
+			c.log.Alert(requestID, fmt.Errorf("Register bans error: %v", err))
+			writeJSON(rw, http.StatusInternalServerError, map[string]any{"error": err.Error(), "registered": registered})
+			return
+		}
+		registered += len(hostBans)
+	}
+
+	writeJSON(rw, http.StatusOK, map[string]any{"registered": registered, "bans": bans})
+}
+
+// splitURL returns the host and request URI of an absolute URL, or of a path on defaultHost.
+func splitURL(defaultHost, raw string) (string, string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid url %q: %v", raw, err)
+	}
+
+	host := defaultHost
+	if u.Host != "" {
+		host = u.Host
+	}
+
+	return host, u.RequestURI(), nil
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v any) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(v)
+}
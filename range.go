@@ -0,0 +1,193 @@
+package traefik_cache
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/ghnexpress/traefik-cache/model"
+)
+
+const maxRanges = 32
+
+type byteRange struct {
+	start, length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// serveRange answers a Range request from a stored 200 response with a 206, a
+// multipart/byteranges 206 for several ranges, or a 416. It returns false when the
+// full response has to be sent: no or invalid Range, or failed If-Range.
+func (c *Cache) serveRange(rw http.ResponseWriter, req *http.Request, value *model.Cache) bool {
+	if req.Method != http.MethodGet || value.Status != http.StatusOK {
+		return false
+	}
+
+	rangeHeader := req.Header.Get("Range")
+	if rangeHeader == "" || !ifRangeMatch(req.Header.Get("If-Range"), value) {
+		return false
+	}
+
+	size := int64(len(value.Body))
+	ranges, ok := parseRange(rangeHeader, size)
+	if !ok {
+		return false
+	}
+
+	header := rw.Header()
+	header.Del("Content-Encoding")
+	header.Set("Accept-Ranges", "bytes")
+
+	if len(ranges) == 0 {
+		header.Del("Content-Type")
+		header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		header.Set("Content-Length", "0")
+		rw.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return true
+	}
+
+	if len(ranges) == 1 {
+		ra := ranges[0]
+		header.Set("Content-Range", ra.contentRange(size))
+		header.Set("Content-Length", strconv.FormatInt(ra.length, 10))
+		rw.WriteHeader(http.StatusPartialContent)
+		rw.Write(value.Body[ra.start : ra.start+ra.length])
+		return true
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	contentType := header.Get("Content-Type")
+
+	for _, ra := range ranges {
+		partHeader := textproto.MIMEHeader{}
+		if contentType != "" {
+			partHeader.Set("Content-Type", contentType)
+		}
+		partHeader.Set("Content-Range", ra.contentRange(size))
+
+		part, err := mw.CreatePart(partHeader)
+		if err != nil {
+			return false
+		}
+		part.Write(value.Body[ra.start : ra.start+ra.length])
+	}
+	mw.Close()
+
+	header.Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	header.Set("Content-Length", strconv.Itoa(buf.Len()))
+	rw.WriteHeader(http.StatusPartialContent)
+	rw.Write(buf.Bytes())
+
+	return true
+}
+
+// ifRangeMatch evaluates If-Range: a strong ETag match or the exact Last-Modified date.
+func ifRangeMatch(ifRange string, value *model.Cache) bool {
+	if ifRange == "" {
+		return true
+	}
+
+	header := http.Header(value.Headers)
+
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		etag := value.ETag
+		if etag == "" {
+			etag = header.Get("ETag")
+		}
+
+		return !strings.HasPrefix(ifRange, "W/") && ifRange == etag
+	}
+
+	lastModified := value.LastModified
+	if lastModified == "" {
+		lastModified = header.Get("Last-Modified")
+	}
+
+	since, err := http.ParseTime(ifRange)
+	if err != nil {
+		return false
+	}
+
+	modified, err := http.ParseTime(lastModified)
+
+	return err == nil && modified.Equal(since)
+}
+
+// parseRange parses a bytes Range header (RFC 9110 §14.1.2) and returns its satisfiable
+// ranges, none meaning 416. ok is false when the header must be ignored.
+func parseRange(s string, size int64) ([]byteRange, bool) {
+	const unit = "bytes="
+	if len(s) < len(unit) || !strings.EqualFold(s[:len(unit)], unit) {
+		return nil, false
+	}
+
+	specs := strings.Split(s[len(unit):], ",")
+	if len(specs) > maxRanges {
+		return nil, false
+	}
+
+	var ranges []byteRange
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		i := strings.Index(spec, "-")
+		if i < 0 {
+			return nil, false
+		}
+
+		first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+
+		if first == "" {
+			suffix, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || suffix < 0 {
+				return nil, false
+			}
+
+			if suffix == 0 || size == 0 {
+				continue
+			}
+
+			if suffix > size {
+				suffix = size
+			}
+
+			ranges = append(ranges, byteRange{start: size - suffix, length: suffix})
+			continue
+		}
+
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 {
+			return nil, false
+		}
+
+		end := size - 1
+		if last != "" {
+			if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+				return nil, false
+			}
+		}
+
+		if start >= size {
+			continue
+		}
+
+		if end >= size {
+			end = size - 1
+		}
+
+		ranges = append(ranges, byteRange{start: start, length: end - start + 1})
+	}
+
+	return ranges, true
+}
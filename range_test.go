@@ -0,0 +1,143 @@
+package traefik_cache
+
+import (
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ghnexpress/traefik-cache/model"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		size   int64
+		want   []byteRange
+		wantOK bool
+	}{
+		{header: "bytes=0-4", size: 10, want: []byteRange{{0, 5}}, wantOK: true},
+		{header: "BYTES=2-", size: 10, want: []byteRange{{2, 8}}, wantOK: true},
+		{header: "bytes=-3", size: 10, want: []byteRange{{7, 3}}, wantOK: true},
+		{header: "bytes=-30", size: 10, want: []byteRange{{0, 10}}, wantOK: true},
+		{header: "bytes=5-100", size: 10, want: []byteRange{{5, 5}}, wantOK: true},
+		{header: "bytes=0-1, 4-5,", size: 10, want: []byteRange{{0, 2}, {4, 2}}, wantOK: true},
+		// Unsatisfiable: 416.
+		{header: "bytes=10-", size: 10, want: nil, wantOK: true},
+		{header: "bytes=-0", size: 10, want: nil, wantOK: true},
+		{header: "bytes=-5", size: 0, want: nil, wantOK: true},
+		{header: "bytes=20-30, 12-", size: 10, want: nil, wantOK: true},
+		{header: "bytes=20-30, 2-3", size: 10, want: []byteRange{{2, 2}}, wantOK: true},
+		// Invalid: ignored.
+		{header: "items=0-4", size: 10},
+		{header: "bytes=4-2", size: 10},
+		{header: "bytes=a-", size: 10},
+		{header: "bytes=5", size: 10},
+		{header: "bytes=" + strings.Repeat("0-0,", maxRanges+1), size: 10},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseRange(tt.header, tt.size)
+		if ok != tt.wantOK || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRange(%q, %d) = %v, %v, want %v, %v", tt.header, tt.size, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestServeRange(t *testing.T) {
+	value := &model.Cache{
+		Status:       http.StatusOK,
+		Headers:      map[string][]string{"Content-Type": {"text/plain"}},
+		Body:         []byte("0123456789"),
+		ETag:         `"v1"`,
+		LastModified: "Tue, 14 Nov 2023 22:13:20 GMT",
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		header       http.Header
+		wantServed   bool
+		wantStatus   int
+		wantRange    string
+		wantBody     string
+		wantMultiple bool
+	}{
+		{name: "no range", header: http.Header{}},
+		{name: "single", header: http.Header{"Range": {"bytes=2-4"}}, wantServed: true, wantStatus: 206, wantRange: "bytes 2-4/10", wantBody: "234"},
+		{name: "suffix", header: http.Header{"Range": {"bytes=-2"}}, wantServed: true, wantStatus: 206, wantRange: "bytes 8-9/10", wantBody: "89"},
+		{name: "clamped", header: http.Header{"Range": {"bytes=7-99"}}, wantServed: true, wantStatus: 206, wantRange: "bytes 7-9/10", wantBody: "789"},
+		{name: "unsatisfiable", header: http.Header{"Range": {"bytes=10-"}}, wantServed: true, wantStatus: 416, wantRange: "bytes */10"},
+		{name: "invalid", header: http.Header{"Range": {"bytes=5-1"}}},
+		{name: "HEAD", method: http.MethodHead, header: http.Header{"Range": {"bytes=2-4"}}},
+		{name: "If-Range ETag", header: http.Header{"Range": {"bytes=0-0"}, "If-Range": {`"v1"`}}, wantServed: true, wantStatus: 206, wantRange: "bytes 0-0/10", wantBody: "0"},
+		{name: "If-Range other ETag", header: http.Header{"Range": {"bytes=0-0"}, "If-Range": {`"v2"`}}},
+		{name: "If-Range weak ETag", header: http.Header{"Range": {"bytes=0-0"}, "If-Range": {`W/"v1"`}}},
+		{name: "If-Range date", header: http.Header{"Range": {"bytes=0-0"}, "If-Range": {"Tue, 14 Nov 2023 22:13:20 GMT"}}, wantServed: true, wantStatus: 206, wantRange: "bytes 0-0/10", wantBody: "0"},
+		{name: "If-Range later date", header: http.Header{"Range": {"bytes=0-0"}, "If-Range": {"Wed, 15 Nov 2023 22:13:20 GMT"}}},
+		{name: "multipart", header: http.Header{"Range": {"bytes=0-1,-2"}}, wantServed: true, wantStatus: 206, wantMultiple: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+
+			req := httptest.NewRequest(method, "http://example.com/", nil)
+			req.Header = tt.header
+			rec := httptest.NewRecorder()
+			rec.Header().Set("Content-Type", "text/plain")
+
+			c := &Cache{}
+			if served := c.serveRange(rec, req, value); served != tt.wantServed {
+				t.Fatalf("serveRange = %v, want %v", served, tt.wantServed)
+			}
+
+			if !tt.wantServed {
+				return
+			}
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			if !tt.wantMultiple {
+				if got := rec.Header().Get("Content-Range"); got != tt.wantRange {
+					t.Errorf("Content-Range %q, want %q", got, tt.wantRange)
+				}
+				if got := rec.Body.String(); got != tt.wantBody {
+					t.Errorf("body %q, want %q", got, tt.wantBody)
+				}
+				return
+			}
+
+			mediaType, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+			if err != nil || mediaType != "multipart/byteranges" {
+				t.Fatalf("Content-Type %q, want multipart/byteranges", rec.Header().Get("Content-Type"))
+			}
+
+			var parts []string
+			r := multipart.NewReader(rec.Body, params["boundary"])
+			for {
+				part, err := r.NextPart()
+				if err != nil {
+					break
+				}
+
+				body, _ := ioutil.ReadAll(part)
+				parts = append(parts, part.Header.Get("Content-Range")+" "+part.Header.Get("Content-Type")+" "+string(body))
+			}
+
+			want := []string{"bytes 0-1/10 text/plain 01", "bytes 8-9/10 text/plain 89"}
+			if !reflect.DeepEqual(parts, want) {
+				t.Errorf("parts %q, want %q", parts, want)
+			}
+		})
+	}
+}
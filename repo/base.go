@@ -2,10 +2,10 @@ package repo
 
 import (
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/ghnexpress/traefik-cache/constants"
 	"github.com/ghnexpress/traefik-cache/model"
 )
 
@@ -13,13 +13,32 @@ type Repository interface {
 	SetExpires(string, time.Time, model.Cache) error
 	Get(string) (*model.Cache, error)
 	Delete(string) error
+	// Increment atomically adds delta to the counter at key, created expiring at t when
+	// missing, and returns its new value. A delta of 0 reads the counter.
+	Increment(key string, delta uint64, t time.Time) (uint64, error)
+}
+
+// New builds the Repository selected by storage.type, memcached being the default.
+func New(cfg model.Config) (Repository, error) {
+	switch constants.StorageType(cfg.Storage.Type) {
+	case "", constants.MemcachedStorageType:
+		return NewRepoManager(cfg.Memcached, cfg.Storage.Compression), nil
+	case constants.MemoryStorageType:
+		return NewMemoryRepo(cfg.Storage.Memory), nil
+	case constants.RedisStorageType:
+		return NewRedisRepo(cfg.Storage.Redis, cfg.Storage.Compression), nil
+	default:
+		return nil, fmt.Errorf("Unknown storage type: %s", cfg.Storage.Type)
+	}
 }
 
 type repoManager struct {
-	db memcache.Client
+	db         *memcache.Client
+	chunkSize  int
+	compressor compressor
 }
 
-func NewRepoManager(cfg model.MemcachedConfig) Repository {
+func NewRepoManager(cfg model.MemcachedConfig, compression model.StorageCompression) Repository {
 	client := memcache.New(cfg.Address)
 
 	if cfg.MaxIdleConnection > 0 {
@@ -30,7 +49,10 @@ func NewRepoManager(cfg model.MemcachedConfig) Repository {
 		client.Timeout = time.Duration(cfg.Timeout) * time.Second
 	}
 
-	os.Stdout.WriteString(fmt.Sprintf("[cache-middleware-plugin] [memcached] Memcached connected, config: %+v\n", client))
+	chunkSize := defaultChunkSize
+	if cfg.ChunkSize > 0 {
+		chunkSize = cfg.ChunkSize
+	}
 
-	return &repoManager{db: *client}
+	return &repoManager{db: client, chunkSize: chunkSize, compressor: newCompressor(compression)}
 }
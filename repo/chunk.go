@@ -0,0 +1,104 @@
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/ghnexpress/traefik-cache/utils"
+)
+
+// memcached rejects items over 1 MB, key and item overhead included.
+const defaultChunkSize = 1000 * 1000
+
+// flagChunked marks a manifest item, whose value describes the chunks holding the payload.
+const flagChunked uint32 = 1 << 0
+
+// chunkManifest is stored under the entry key when the payload is split in several items.
+// ID changes on every write so that a reader never mixes chunks of two writes.
+type chunkManifest struct {
+	ID       string `json:"id"`
+	Chunks   int    `json:"chunks"`
+	Size     int    `json:"size"`
+	Checksum string `json:"checksum"`
+}
+
+func chunkKey(key, id string, i int) string {
+	return fmt.Sprintf("%s:%s:%d", key, id, i)
+}
+
+// setChunked writes the chunks first and the manifest last, so that a manifest is only
+// visible once all of its chunks are.
+func (r *repoManager) setChunked(key string, b []byte, flags uint32, expiration int32) error {
+	m := chunkManifest{
+		ID:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		Chunks:   (len(b) + r.chunkSize - 1) / r.chunkSize,
+		Size:     len(b),
+		Checksum: utils.GetMD5Hash(b),
+	}
+
+	for i := 0; i < m.Chunks; i++ {
+		end := (i + 1) * r.chunkSize
+		if end > len(b) {
+			end = len(b)
+		}
+
+		err := r.db.Set(&memcache.Item{
+			Key:        chunkKey(key, m.ID, i),
+			Value:      b[i*r.chunkSize : end],
+			Expiration: expiration,
+		})
+		if err != nil {
+			return fmt.Errorf("Set chunk %d/%d to memcached error: %v", i+1, m.Chunks, err)
+		}
+	}
+
+	manifest, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("Marshal chunk manifest error: %v", err)
+	}
+
+	return r.db.Set(&memcache.Item{
+		Key:        key,
+		Value:      manifest,
+		Flags:      flags | flagChunked,
+		Expiration: expiration,
+	})
+}
+
+// getChunked reassembles the payload described by a manifest item. A missing chunk or a
+// checksum mismatch is reported as a miss.
+func (r *repoManager) getChunked(key string, manifest []byte) ([]byte, error) {
+	var m chunkManifest
+	if err := json.Unmarshal(manifest, &m); err != nil {
+		return nil, fmt.Errorf("Unmarshal chunk manifest error: %v", err)
+	}
+
+	keys := make([]string, m.Chunks)
+	for i := range keys {
+		keys[i] = chunkKey(key, m.ID, i)
+	}
+
+	items, err := r.db.GetMulti(keys)
+	if err != nil {
+		return nil, fmt.Errorf("Get chunks from memcached error: %v", err)
+	}
+
+	b := make([]byte, 0, m.Size)
+	for _, k := range keys {
+		item, ok := items[k]
+		if !ok {
+			return nil, nil
+		}
+
+		b = append(b, item.Value...)
+	}
+
+	if len(b) != m.Size || utils.GetMD5Hash(b) != m.Checksum {
+		return nil, nil
+	}
+
+	return b, nil
+}
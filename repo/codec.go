@@ -0,0 +1,190 @@
+package repo
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/ghnexpress/traefik-cache/model"
+)
+
+// Entries are stored in a binary envelope: magic byte, version, status, headers, metadata,
+// body length, then the raw body. Values written before the envelope are JSON documents,
+// which never start with the magic byte, and are still decoded.
+const (
+	codecMagic   byte = 0xC7
+	codecVersion byte = 1
+)
+
+var errShortEnvelope = errors.New("truncated cache envelope")
+
+// encodeHead returns the envelope of data up to the body length; the raw body follows it.
+func encodeHead(data model.Cache) []byte {
+	b := make([]byte, 0, 256)
+	b = append(b, codecMagic, codecVersion)
+	b = binary.AppendUvarint(b, uint64(data.Status))
+
+	b = binary.AppendUvarint(b, uint64(len(data.Headers)))
+	for k, vals := range data.Headers {
+		b = appendString(b, k)
+		b = appendStrings(b, vals)
+	}
+
+	b = binary.AppendVarint(b, data.Expires)
+	b = binary.AppendVarint(b, data.StaleWhileRevalidate)
+	b = binary.AppendVarint(b, data.StaleIfError)
+	b = appendString(b, data.ETag)
+	b = appendString(b, data.LastModified)
+	b = binary.AppendVarint(b, data.StoredAt)
+	b = appendStrings(b, data.VaryIndex)
+	b = appendString(b, data.URL)
+	b = appendStrings(b, data.Tags)
+
+	return binary.AppendUvarint(b, uint64(len(data.Body)))
+}
+
+func marshalCache(data model.Cache) []byte {
+	head := encodeHead(data)
+
+	b := make([]byte, 0, len(head)+len(data.Body))
+	b = append(b, head...)
+
+	return append(b, data.Body...)
+}
+
+func unmarshalCache(b []byte) (*model.Cache, error) {
+	if len(b) == 0 || b[0] != codecMagic {
+		var d model.Cache
+		if err := json.Unmarshal(b, &d); err != nil {
+			return nil, fmt.Errorf("Unmarshal cache data error: %v", err)
+		}
+
+		return &d, nil
+	}
+
+	if len(b) < 2 || b[1] != codecVersion {
+		return nil, fmt.Errorf("Unmarshal cache data error: unsupported envelope version")
+	}
+
+	r := &envelopeReader{b: b, off: 2}
+
+	var d model.Cache
+	d.Status = int(r.uvarint())
+
+	if n := r.uvarint(); n > 0 && r.err == nil {
+		d.Headers = make(map[string][]string, n)
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			k := r.string()
+			d.Headers[k] = r.strings()
+		}
+	}
+
+	d.Expires = r.varint()
+	d.StaleWhileRevalidate = r.varint()
+	d.StaleIfError = r.varint()
+	d.ETag = r.string()
+	d.LastModified = r.string()
+	d.StoredAt = r.varint()
+	d.VaryIndex = r.strings()
+	d.URL = r.string()
+	d.Tags = r.strings()
+	d.Body = r.bytes()
+
+	if r.err != nil {
+		return nil, fmt.Errorf("Unmarshal cache data error: %v", r.err)
+	}
+
+	return &d, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendStrings(b []byte, ss []string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(ss)))
+	for _, s := range ss {
+		b = appendString(b, s)
+	}
+
+	return b
+}
+
+// envelopeReader decodes the envelope fields in order, keeping the first error.
+type envelopeReader struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (r *envelopeReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(r.b[r.off:])
+	if n <= 0 {
+		r.err = errShortEnvelope
+		return 0
+	}
+	r.off += n
+
+	return v
+}
+
+func (r *envelopeReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+
+	v, n := binary.Varint(r.b[r.off:])
+	if n <= 0 {
+		r.err = errShortEnvelope
+		return 0
+	}
+	r.off += n
+
+	return v
+}
+
+func (r *envelopeReader) bytes() []byte {
+	n := r.uvarint()
+	if r.err != nil {
+		return nil
+	}
+
+	if n > uint64(len(r.b)-r.off) {
+		r.err = errShortEnvelope
+		return nil
+	}
+
+	v := r.b[r.off : r.off+int(n)]
+	r.off += int(n)
+
+	return v
+}
+
+func (r *envelopeReader) string() string {
+	return string(r.bytes())
+}
+
+func (r *envelopeReader) strings() []string {
+	n := r.uvarint()
+	if r.err != nil || n == 0 {
+		return nil
+	}
+
+	if n > uint64(len(r.b)-r.off) {
+		r.err = errShortEnvelope
+		return nil
+	}
+
+	ss := make([]string, n)
+	for i := range ss {
+		ss[i] = r.string()
+	}
+
+	return ss
+}
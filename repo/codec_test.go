@@ -0,0 +1,132 @@
+package repo
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/ghnexpress/traefik-cache/model"
+)
+
+func testCache(bodySize int) model.Cache {
+	return model.Cache{
+		Status: 200,
+		Headers: map[string][]string{
+			"Content-Type":  {"application/json; charset=utf-8"},
+			"Cache-Control": {"public, max-age=60"},
+			"Etag":          {`"33a64df551425fcc55e4d42a148795d9f25f89d4"`},
+			"Vary":          {"Accept-Language"},
+		},
+		Body:                 bytes.Repeat([]byte(`{"id":1,"name":"item"},`), bodySize/23+1)[:bodySize],
+		Expires:              1700000060,
+		StaleWhileRevalidate: 30,
+		StaleIfError:         300,
+		ETag:                 `"33a64df551425fcc55e4d42a148795d9f25f89d4"`,
+		LastModified:         "Tue, 14 Nov 2023 22:13:20 GMT",
+		StoredAt:             1700000000000000000,
+		URL:                  "/items?page=1",
+		Tags:                 []string{"items", "page-1"},
+	}
+}
+
+func TestMarshalCacheRoundTrip(t *testing.T) {
+	want := testCache(1024)
+
+	got, err := unmarshalCache(marshalCache(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(*got, want) {
+		t.Errorf("unmarshalCache(marshalCache(v)) = %+v, want %+v", *got, want)
+	}
+}
+
+func TestUnmarshalCacheJSONFallback(t *testing.T) {
+	// An entry written before the binary envelope.
+	want := testCache(64)
+	want.StoredAt = 1700000000
+	b, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := unmarshalCache(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(*got, want) {
+		t.Errorf("unmarshalCache(json) = %+v, want %+v", *got, want)
+	}
+}
+
+func TestUnmarshalCacheTruncated(t *testing.T) {
+	b := marshalCache(testCache(64))
+
+	if _, err := unmarshalCache(b[:len(b)-1]); err == nil {
+		t.Error("unmarshalCache of a truncated envelope: expected an error")
+	}
+}
+
+var benchmarkSizes = []struct {
+	name string
+	size int
+}{
+	{"1KB", 1 << 10},
+	{"64KB", 64 << 10},
+	{"1MB", 1 << 20},
+}
+
+func BenchmarkMarshalCache(b *testing.B) {
+	for _, bs := range benchmarkSizes {
+		data := testCache(bs.size)
+
+		b.Run("json/"+bs.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := json.Marshal(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run("binary/"+bs.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				marshalCache(data)
+			}
+		})
+	}
+}
+
+func BenchmarkUnmarshalCache(b *testing.B) {
+	for _, bs := range benchmarkSizes {
+		data := testCache(bs.size)
+
+		jsonData, err := json.Marshal(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		binaryData := marshalCache(data)
+
+		b.Run("json/"+bs.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := unmarshalCache(jsonData); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run("binary/"+bs.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := unmarshalCache(binaryData); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package repo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"sync/atomic"
+
+	"github.com/ghnexpress/traefik-cache/model"
+)
+
+const defaultCompressionMinSize = 1024 // byte
+
+// flagGzip marks a memcached item whose payload is gzip compressed.
+const flagGzip uint32 = 1 << 1
+
+// compressedMagic starts the gzip compressed values of backends without item flags.
+const compressedMagic byte = 0xC8
+
+// Bytes of payload written by the repositories before and after compression.
+var (
+	rawBytes    int64
+	storedBytes int64
+)
+
+// CompressionStats returns the payload bytes written before and after compression, the
+// saved ratio being 1 - stored/raw.
+func CompressionStats() (int64, int64) {
+	return atomic.LoadInt64(&rawBytes), atomic.LoadInt64(&storedBytes)
+}
+
+type compressor struct {
+	enable  bool
+	minSize int
+}
+
+func newCompressor(cfg model.StorageCompression) compressor {
+	minSize := cfg.MinSize
+	if minSize <= 0 {
+		minSize = defaultCompressionMinSize
+	}
+
+	return compressor{enable: cfg.Enable, minSize: minSize}
+}
+
+// compress returns the gzip compressed payload and true when compression is enabled, the
+// payload above the threshold and the result smaller.
+func (c compressor) compress(b []byte) ([]byte, bool) {
+	if !c.enable {
+		return b, false
+	}
+
+	atomic.AddInt64(&rawBytes, int64(len(b)))
+
+	if len(b) >= c.minSize {
+		var buf bytes.Buffer
+		w, _ := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+		if _, err := w.Write(b); err == nil && w.Close() == nil && buf.Len() < len(b) {
+			atomic.AddInt64(&storedBytes, int64(buf.Len()))
+			return buf.Bytes(), true
+		}
+	}
+
+	atomic.AddInt64(&storedBytes, int64(len(b)))
+
+	return b, false
+}
+
+func decompress(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
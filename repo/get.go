@@ -1,7 +1,6 @@
 package repo
 
 import (
-	"encoding/json"
 	"fmt"
 
 	"github.com/bradfitz/gomemcache/memcache"
@@ -22,10 +21,18 @@ func (r *repoManager) Get(key string) (*model.Cache, error) {
 		return nil, nil
 	}
 
-	var d model.Cache
-	if err = json.Unmarshal(item.Value, &d); err != nil {
-		return nil, fmt.Errorf("Unmarshal cache data error: %v", err)
+	value := item.Value
+	if item.Flags&flagChunked != 0 {
+		if value, err = r.getChunked(key, item.Value); err != nil || value == nil {
+			return nil, err
+		}
+	}
+
+	if item.Flags&flagGzip != 0 {
+		if value, err = decompress(value); err != nil {
+			return nil, fmt.Errorf("Decompress cache data error: %v", err)
+		}
 	}
 
-	return &d, nil
+	return unmarshalCache(value)
 }
@@ -0,0 +1,39 @@
+package repo
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+func (r *repoManager) Increment(key string, delta uint64, t time.Time) (uint64, error) {
+	for {
+		value, err := r.db.Increment(key, delta)
+		if err == nil {
+			return value, nil
+		}
+		if err != memcache.ErrCacheMiss {
+			return 0, fmt.Errorf("Increment counter in memcached error: %v", err)
+		}
+
+		if delta == 0 {
+			return 0, nil
+		}
+
+		err = r.db.Add(&memcache.Item{
+			Key:        key,
+			Value:      []byte(strconv.FormatUint(delta, 10)),
+			Expiration: int32(time.Until(t).Seconds()),
+		})
+		if err == nil {
+			return delta, nil
+		}
+
+		// Created meanwhile by another client: increment it.
+		if err != memcache.ErrNotStored {
+			return 0, fmt.Errorf("Increment counter in memcached error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package repo
+
+import (
+	"container/list"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ghnexpress/traefik-cache/model"
+)
+
+const defaultMemoryMaxSize = 64 // megabyte
+
+type memoryItem struct {
+	key       string
+	value     model.Cache
+	size      int
+	expiresAt time.Time
+}
+
+type memoryCounter struct {
+	value     uint64
+	expiresAt time.Time
+}
+
+// memoryRepo is an in-process LRU bounded by the total size of the stored entries
+// and, optionally, by their number. Counters are kept apart, out of the LRU.
+type memoryRepo struct {
+	mu         sync.Mutex
+	items      map[string]*list.Element
+	ll         *list.List
+	size       int
+	maxSize    int
+	maxEntries int
+	counters   map[string]*memoryCounter
+}
+
+func NewMemoryRepo(cfg model.MemoryConfig) Repository {
+	maxSize := cfg.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultMemoryMaxSize
+	}
+
+	return &memoryRepo{
+		items:      make(map[string]*list.Element),
+		ll:         list.New(),
+		maxSize:    maxSize * 1024 * 1024,
+		maxEntries: cfg.MaxEntries,
+		counters:   make(map[string]*memoryCounter),
+	}
+}
+
+func (r *memoryRepo) Get(key string) (*model.Cache, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.items[key]
+	if !ok {
+		return nil, nil
+	}
+
+	item := e.Value.(*memoryItem)
+	if !time.Now().Before(item.expiresAt) {
+		r.removeElement(e)
+		return nil, nil
+	}
+
+	r.ll.MoveToFront(e)
+
+	d := item.value
+	d.Headers = http.Header(item.value.Headers).Clone()
+
+	return &d, nil
+}
+
+func (r *memoryRepo) SetExpires(key string, t time.Time, data model.Cache) error {
+	if !time.Now().Before(t) {
+		return nil
+	}
+
+	data.Headers = http.Header(data.Headers).Clone()
+	item := &memoryItem{
+		key:       key,
+		value:     data,
+		size:      entrySize(key, data),
+		expiresAt: t,
+	}
+
+	if item.size > r.maxSize {
+		return fmt.Errorf("Set data to memory error: entry size %d exceeds max size %d", item.size, r.maxSize)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e, ok := r.items[key]; ok {
+		r.removeElement(e)
+	}
+
+	r.items[key] = r.ll.PushFront(item)
+	r.size += item.size
+
+	for r.size > r.maxSize || (r.maxEntries > 0 && r.ll.Len() > r.maxEntries) {
+		r.removeElement(r.ll.Back())
+	}
+
+	return nil
+}
+
+func (r *memoryRepo) Delete(key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e, ok := r.items[key]; ok {
+		r.removeElement(e)
+	}
+
+	return nil
+}
+
+func (r *memoryRepo) Increment(key string, delta uint64, t time.Time) (uint64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for k, counter := range r.counters {
+		if !now.Before(counter.expiresAt) {
+			delete(r.counters, k)
+		}
+	}
+
+	counter, ok := r.counters[key]
+	if !ok {
+		if delta == 0 {
+			return 0, nil
+		}
+
+		counter = &memoryCounter{expiresAt: t}
+		r.counters[key] = counter
+	}
+	counter.value += delta
+
+	return counter.value, nil
+}
+
+func (r *memoryRepo) removeElement(e *list.Element) {
+	item := e.Value.(*memoryItem)
+
+	r.ll.Remove(e)
+	delete(r.items, item.key)
+	r.size -= item.size
+}
+
+// entrySize approximates the memory held by an entry: key, headers and body.
+func entrySize(key string, data model.Cache) int {
+	size := len(key) + len(data.Body)
+	for k, vals := range data.Headers {
+		size += len(k)
+		for _, v := range vals {
+			size += len(v)
+		}
+	}
+
+	return size
+}
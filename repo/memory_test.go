@@ -0,0 +1,197 @@
+package repo
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghnexpress/traefik-cache/model"
+)
+
+func TestMemoryRepoSizeAccounting(t *testing.T) {
+	r := NewMemoryRepo(model.MemoryConfig{}).(*memoryRepo)
+	expires := time.Now().Add(time.Minute)
+
+	a, b := testCache(100), testCache(300)
+	if err := r.SetExpires("a", expires, a); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetExpires("b", expires, b); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := entrySize("a", a) + entrySize("b", b); r.size != want {
+		t.Errorf("size %d, want %d", r.size, want)
+	}
+
+	// Replacing an entry accounts for the new value only.
+	if err := r.SetExpires("a", expires, testCache(10)); err != nil {
+		t.Fatal(err)
+	}
+	if want := entrySize("a", testCache(10)) + entrySize("b", b); r.size != want {
+		t.Errorf("size after replace %d, want %d", r.size, want)
+	}
+
+	if err := r.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Delete("missing"); err != nil {
+		t.Fatal(err)
+	}
+	if want := entrySize("b", b); r.size != want || r.ll.Len() != 1 || len(r.items) != 1 {
+		t.Errorf("size after delete %d with %d entries, want %d with 1", r.size, r.ll.Len(), want)
+	}
+}
+
+func TestMemoryRepoSizeEviction(t *testing.T) {
+	r := NewMemoryRepo(model.MemoryConfig{MaxSize: 1}).(*memoryRepo)
+	expires := time.Now().Add(time.Minute)
+
+	for _, key := range []string{"a", "b"} {
+		if err := r.SetExpires(key, expires, testCache(400<<10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// "a" is now the most recently used.
+	if got, _ := r.Get("a"); got == nil {
+		t.Fatal("a not stored")
+	}
+
+	if err := r.SetExpires("c", expires, testCache(400<<10)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := r.Get("b"); got != nil {
+		t.Error("the least recently used entry was not evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if got, _ := r.Get(key); got == nil {
+			t.Errorf("%s evicted", key)
+		}
+	}
+
+	if r.size > r.maxSize {
+		t.Errorf("size %d over max size %d", r.size, r.maxSize)
+	}
+}
+
+func TestMemoryRepoEntryCountEviction(t *testing.T) {
+	r := NewMemoryRepo(model.MemoryConfig{MaxEntries: 2}).(*memoryRepo)
+	expires := time.Now().Add(time.Minute)
+
+	for _, key := range []string{"a", "b"} {
+		if err := r.SetExpires(key, expires, testCache(16)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r.Get("a")
+
+	if err := r.SetExpires("c", expires, testCache(16)); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.ll.Len() != 2 {
+		t.Errorf("%d entries, want 2", r.ll.Len())
+	}
+	if got, _ := r.Get("b"); got != nil {
+		t.Error("the least recently used entry was not evicted")
+	}
+	if want := entrySize("a", testCache(16)) + entrySize("c", testCache(16)); r.size != want {
+		t.Errorf("size %d, want %d", r.size, want)
+	}
+}
+
+func TestMemoryRepoExpiration(t *testing.T) {
+	r := NewMemoryRepo(model.MemoryConfig{}).(*memoryRepo)
+
+	if err := r.SetExpires("past", time.Now().Add(-time.Second), testCache(16)); err != nil {
+		t.Fatal(err)
+	}
+	if r.ll.Len() != 0 {
+		t.Error("an already expired entry was stored")
+	}
+
+	if err := r.SetExpires("key", time.Now().Add(50*time.Millisecond), testCache(16)); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := r.Get("key"); got == nil {
+		t.Fatal("entry not stored")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	if got, err := r.Get("key"); err != nil || got != nil {
+		t.Errorf("Get after expiration = %+v, %v, want nil, nil", got, err)
+	}
+	if r.size != 0 || len(r.items) != 0 {
+		t.Errorf("expired entry still accounted: size %d, %d entries", r.size, len(r.items))
+	}
+}
+
+func TestMemoryRepoOversize(t *testing.T) {
+	r := NewMemoryRepo(model.MemoryConfig{MaxSize: 1}).(*memoryRepo)
+	expires := time.Now().Add(time.Minute)
+
+	if err := r.SetExpires("small", expires, testCache(16)); err != nil {
+		t.Fatal(err)
+	}
+
+	err := r.SetExpires("large", expires, testCache(1<<20))
+	if err == nil || !strings.Contains(err.Error(), "exceeds max size") {
+		t.Fatalf("SetExpires of an oversize entry error = %v", err)
+	}
+
+	// Rejected without evicting anything.
+	if got, _ := r.Get("small"); got == nil {
+		t.Error("an oversize entry evicted the others")
+	}
+	if got, _ := r.Get("large"); got != nil {
+		t.Error("oversize entry stored")
+	}
+}
+
+func TestMemoryRepoGetCopiesHeaders(t *testing.T) {
+	r := NewMemoryRepo(model.MemoryConfig{})
+	if err := r.SetExpires("key", time.Now().Add(time.Minute), testCache(16)); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := r.Get("key")
+	got.Headers["Vary"] = []string{"Cookie"}
+
+	if again, _ := r.Get("key"); again.Headers["Vary"][0] != "Accept-Language" {
+		t.Error("headers of a returned entry alias the stored ones")
+	}
+}
+
+func TestMemoryRepoIncrement(t *testing.T) {
+	r := NewMemoryRepo(model.MemoryConfig{MaxEntries: 1})
+
+	for _, step := range []struct {
+		delta uint64
+		want  uint64
+	}{{0, 0}, {3, 3}, {0, 3}, {2, 5}} {
+		got, err := r.Increment("counter", step.delta, time.Now().Add(50*time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != step.want {
+			t.Errorf("Increment(%d) = %d, want %d", step.delta, got, step.want)
+		}
+	}
+
+	// Counters are not evicted by entries.
+	r.SetExpires("a", time.Now().Add(time.Minute), testCache(16))
+	r.SetExpires("b", time.Now().Add(time.Minute), testCache(16))
+	if got, _ := r.Increment("counter", 0, time.Time{}); got != 5 {
+		t.Errorf("counter %d after entries were evicted, want 5", got)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	if got, _ := r.Increment("counter", 0, time.Time{}); got != 0 {
+		t.Errorf("expired counter %d, want 0", got)
+	}
+}
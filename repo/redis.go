@@ -0,0 +1,104 @@
+package repo
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ghnexpress/traefik-cache/model"
+)
+
+type redisRepo struct {
+	pool       *redisPool
+	compressor compressor
+}
+
+func NewRedisRepo(cfg model.RedisConfig, compression model.StorageCompression) Repository {
+	pool := &redisPool{
+		address:  cfg.Address,
+		username: cfg.Username,
+		password: cfg.Password,
+		db:       cfg.DB,
+		timeout:  time.Second,
+		maxIdle:  defaultRedisMaxIdleConns,
+	}
+
+	if cfg.MaxIdleConnection > 0 {
+		pool.maxIdle = cfg.MaxIdleConnection
+	}
+
+	if cfg.Timeout > 0 {
+		pool.timeout = time.Duration(cfg.Timeout) * time.Second
+	}
+
+	return &redisRepo{pool: pool, compressor: newCompressor(compression)}
+}
+
+func (r *redisRepo) Get(key string) (*model.Cache, error) {
+	reply, err := r.pool.do([]byte("GET"), []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("Get data from redis error: %v", err)
+	}
+
+	value, _ := reply.([]byte)
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	if value[0] == compressedMagic {
+		if value, err = decompress(value[1:]); err != nil {
+			return nil, fmt.Errorf("Decompress cache data error: %v", err)
+		}
+	}
+
+	return unmarshalCache(value)
+}
+
+func (r *redisRepo) SetExpires(key string, t time.Time, data model.Cache) error {
+	expiration := time.Until(t).Milliseconds()
+	if expiration <= 0 {
+		return nil
+	}
+
+	// The body is written to the connection after the envelope head, not copied into it.
+	value := [][]byte{encodeHead(data), data.Body}
+	if r.compressor.enable {
+		if b, compressed := r.compressor.compress(marshalCache(data)); compressed {
+			value = [][]byte{{compressedMagic}, b}
+		}
+	}
+	_, err := r.pool.do([]byte("SET"), []byte(key), value, []byte("PX"), []byte(strconv.FormatInt(expiration, 10)))
+	if err != nil {
+		return fmt.Errorf("Set data to redis error: %v", err)
+	}
+
+	return nil
+}
+
+func (r *redisRepo) Increment(key string, delta uint64, t time.Time) (uint64, error) {
+	replies, err := r.pool.pipeline(
+		[]any{[]byte("INCRBY"), []byte(key), []byte(strconv.FormatUint(delta, 10))},
+		[]any{[]byte("PEXPIREAT"), []byte(key), []byte(strconv.FormatInt(t.UnixMilli(), 10))},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("Increment counter in redis error: %v", err)
+	}
+
+	for _, reply := range replies {
+		if err, ok := reply.(redisError); ok {
+			return 0, fmt.Errorf("Increment counter in redis error: %v", err)
+		}
+	}
+
+	value, _ := replies[0].(int64)
+
+	return uint64(value), nil
+}
+
+func (r *redisRepo) Delete(key string) error {
+	if _, err := r.pool.do([]byte("DEL"), []byte(key)); err != nil {
+		return fmt.Errorf("Delete data from redis error: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,419 @@
+package repo
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ghnexpress/traefik-cache/model"
+)
+
+// respServer is an in-process stand-in for a Redis server, speaking enough RESP2 for the
+// repository: AUTH, SELECT, GET, SET with PX, DEL, INCRBY and PEXPIREAT.
+type respServer struct {
+	ln       net.Listener
+	username string
+	password string
+
+	mu    sync.Mutex
+	dbs   map[int]map[string]respValue
+	dials int
+	// fail maps a command name to the error reply sent instead of executing it.
+	fail map[string]string
+}
+
+type respValue struct {
+	value   []byte
+	expires time.Time
+}
+
+// newRESPServer starts a server requiring AUTH with username and password, unless the
+// password is empty.
+func newRESPServer(t *testing.T, username, password string) *respServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &respServer{
+		ln:       ln,
+		username: username,
+		password: password,
+		dbs:      make(map[int]map[string]respValue),
+		fail:     make(map[string]string),
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go s.serve()
+
+	return s
+}
+
+func (s *respServer) addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *respServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.dials++
+		s.mu.Unlock()
+
+		go s.handle(conn)
+	}
+}
+
+func (s *respServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	authed, db := s.password == "", 0
+
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			return
+		}
+
+		items, _ := reply.([]any)
+		args := make([]string, len(items))
+		for i, item := range items {
+			b, _ := item.([]byte)
+			args[i] = string(b)
+		}
+
+		if len(args) == 0 {
+			w.WriteString("-ERR empty command\r\n")
+		} else {
+			s.exec(w, args, &authed, &db)
+		}
+
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *respServer) exec(w *bufio.Writer, args []string, authed *bool, db *int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cmd := strings.ToUpper(args[0])
+	if msg, ok := s.fail[cmd]; ok {
+		w.WriteString("-" + msg + "\r\n")
+		return
+	}
+
+	if cmd == "AUTH" {
+		username, password := "default", args[len(args)-1]
+		if len(args) == 3 {
+			username = args[1]
+		}
+
+		wantUsername := s.username
+		if wantUsername == "" {
+			wantUsername = "default"
+		}
+
+		if username != wantUsername || password != s.password {
+			w.WriteString("-WRONGPASS invalid username-password pair or user is disabled.\r\n")
+			return
+		}
+
+		*authed = true
+		w.WriteString("+OK\r\n")
+		return
+	}
+
+	if !*authed {
+		w.WriteString("-NOAUTH Authentication required.\r\n")
+		return
+	}
+
+	values := s.dbs[*db]
+	if values == nil {
+		values = make(map[string]respValue)
+		s.dbs[*db] = values
+	}
+
+	switch cmd {
+	case "SELECT":
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 || n > 15 {
+			w.WriteString("-ERR DB index is out of range\r\n")
+			return
+		}
+
+		*db = n
+		w.WriteString("+OK\r\n")
+	case "GET":
+		v, ok := values[args[1]]
+		if !ok || (!v.expires.IsZero() && time.Now().After(v.expires)) {
+			w.WriteString("$-1\r\n")
+			return
+		}
+
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v.value), v.value)
+	case "SET":
+		v := respValue{value: []byte(args[2])}
+		if len(args) == 5 && strings.ToUpper(args[3]) == "PX" {
+			ms, err := strconv.Atoi(args[4])
+			if err != nil || ms <= 0 {
+				w.WriteString("-ERR invalid expire time in 'set' command\r\n")
+				return
+			}
+
+			v.expires = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+
+		values[args[1]] = v
+		w.WriteString("+OK\r\n")
+	case "DEL":
+		_, ok := values[args[1]]
+		delete(values, args[1])
+
+		if ok {
+			w.WriteString(":1\r\n")
+		} else {
+			w.WriteString(":0\r\n")
+		}
+	case "INCRBY":
+		v, ok := values[args[1]]
+		if ok && !v.expires.IsZero() && time.Now().After(v.expires) {
+			v = respValue{}
+		}
+
+		n, _ := strconv.ParseInt(string(v.value), 10, 64)
+		delta, _ := strconv.ParseInt(args[2], 10, 64)
+		v.value = []byte(strconv.FormatInt(n+delta, 10))
+		values[args[1]] = v
+		fmt.Fprintf(w, ":%d\r\n", n+delta)
+	case "PEXPIREAT":
+		v, ok := values[args[1]]
+		if !ok {
+			w.WriteString(":0\r\n")
+			return
+		}
+
+		ms, _ := strconv.ParseInt(args[2], 10, 64)
+		v.expires = time.UnixMilli(ms)
+		values[args[1]] = v
+		w.WriteString(":1\r\n")
+	default:
+		fmt.Fprintf(w, "-ERR unknown command '%s'\r\n", args[0])
+	}
+}
+
+func (s *respServer) stored(db int, key string) (respValue, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.dbs[db][key]
+
+	return v, ok
+}
+
+func (s *respServer) setFail(cmd, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if msg == "" {
+		delete(s.fail, cmd)
+		return
+	}
+
+	s.fail[cmd] = msg
+}
+
+func TestRedisRepoSetGetDelete(t *testing.T) {
+	s := newRESPServer(t, "", "")
+	r := NewRedisRepo(model.RedisConfig{Address: s.addr()}, model.StorageCompression{})
+
+	want := testCache(2048)
+	if err := r.SetExpires("key", time.Now().Add(time.Minute), want); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := r.Get("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || !reflect.DeepEqual(*got, want) {
+		t.Fatalf("Get = %+v, want %+v", got, want)
+	}
+
+	if err := r.Delete("key"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := r.Get("key"); err != nil || got != nil {
+		t.Fatalf("Get after Delete = %+v, %v, want nil, nil", got, err)
+	}
+}
+
+func TestRedisRepoExpiration(t *testing.T) {
+	s := newRESPServer(t, "", "")
+	r := NewRedisRepo(model.RedisConfig{Address: s.addr()}, model.StorageCompression{})
+
+	if err := r.SetExpires("past", time.Now().Add(-time.Second), testCache(16)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.stored(0, "past"); ok {
+		t.Error("an already expired entry was sent to the server")
+	}
+
+	if err := r.SetExpires("key", time.Now().Add(100*time.Millisecond), testCache(16)); err != nil {
+		t.Fatal(err)
+	}
+
+	v, ok := s.stored(0, "key")
+	if !ok {
+		t.Fatal("entry not stored")
+	}
+	if ttl := time.Until(v.expires); ttl <= 0 || ttl > 100*time.Millisecond {
+		t.Errorf("PX ttl = %v, want within 100ms", ttl)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	if got, err := r.Get("key"); err != nil || got != nil {
+		t.Errorf("Get after expiration = %+v, %v, want nil, nil", got, err)
+	}
+}
+
+func TestRedisRepoIncrement(t *testing.T) {
+	s := newRESPServer(t, "", "")
+	r := NewRedisRepo(model.RedisConfig{Address: s.addr()}, model.StorageCompression{})
+
+	expires := time.Now().Add(time.Minute)
+	for _, step := range []struct {
+		delta uint64
+		want  uint64
+	}{{0, 0}, {3, 3}, {0, 3}, {2, 5}} {
+		got, err := r.Increment("counter", step.delta, expires)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != step.want {
+			t.Errorf("Increment(%d) = %d, want %d", step.delta, got, step.want)
+		}
+	}
+
+	v, _ := s.stored(0, "counter")
+	if v.expires.UnixMilli() != expires.UnixMilli() {
+		t.Errorf("counter expires at %v, want %v", v.expires, expires)
+	}
+}
+
+func TestRedisRepoCompression(t *testing.T) {
+	s := newRESPServer(t, "", "")
+	r := NewRedisRepo(model.RedisConfig{Address: s.addr()}, model.StorageCompression{Enable: true, MinSize: 1024})
+
+	want := testCache(64 << 10)
+	if err := r.SetExpires("key", time.Now().Add(time.Minute), want); err != nil {
+		t.Fatal(err)
+	}
+
+	v, _ := s.stored(0, "key")
+	if len(v.value) == 0 || v.value[0] != compressedMagic {
+		t.Fatal("value not stored compressed")
+	}
+	if len(v.value) >= len(want.Body) {
+		t.Errorf("compressed value of %d bytes for a body of %d bytes", len(v.value), len(want.Body))
+	}
+
+	got, err := r.Get("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || !reflect.DeepEqual(*got, want) {
+		t.Fatal("compressed entry does not round trip")
+	}
+
+	// Small entries are stored as is.
+	if err := r.SetExpires("small", time.Now().Add(time.Minute), testCache(8)); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.stored(0, "small"); v.value[0] != codecMagic {
+		t.Error("value under minSize stored compressed")
+	}
+}
+
+func TestRedisRepoAuthSelect(t *testing.T) {
+	s := newRESPServer(t, "cache", "secret")
+
+	r := NewRedisRepo(model.RedisConfig{Address: s.addr(), Username: "cache", Password: "secret", DB: 2}, model.StorageCompression{})
+	if err := r.SetExpires("key", time.Now().Add(time.Minute), testCache(16)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s.stored(2, "key"); !ok {
+		t.Error("entry not stored in the selected db")
+	}
+	if _, ok := s.stored(0, "key"); ok {
+		t.Error("entry stored in db 0")
+	}
+
+	if got, err := r.Get("key"); err != nil || got == nil {
+		t.Errorf("Get = %+v, %v", got, err)
+	}
+
+	wrong := NewRedisRepo(model.RedisConfig{Address: s.addr(), Username: "cache", Password: "wrong"}, model.StorageCompression{})
+	if _, err := wrong.Get("key"); err == nil || !strings.Contains(err.Error(), "auth: WRONGPASS") {
+		t.Errorf("Get with a wrong password error = %v, want an auth error", err)
+	}
+
+	outOfRange := NewRedisRepo(model.RedisConfig{Address: s.addr(), Username: "cache", Password: "secret", DB: 16}, model.StorageCompression{})
+	if _, err := outOfRange.Get("key"); err == nil || !strings.Contains(err.Error(), "select db 16") {
+		t.Errorf("Get with an out of range db error = %v, want a select error", err)
+	}
+}
+
+func TestRedisRepoErrorReply(t *testing.T) {
+	s := newRESPServer(t, "", "")
+	r := NewRedisRepo(model.RedisConfig{Address: s.addr()}, model.StorageCompression{})
+
+	s.setFail("SET", "OOM command not allowed when used memory > 'maxmemory'.")
+	err := r.SetExpires("key", time.Now().Add(time.Minute), testCache(16))
+	if err == nil || !strings.Contains(err.Error(), "OOM") {
+		t.Fatalf("SetExpires error = %v, want the OOM error reply", err)
+	}
+
+	s.setFail("GET", "ERR something went wrong")
+	if _, err := r.Get("key"); err == nil || !strings.Contains(err.Error(), "something went wrong") {
+		t.Fatalf("Get error = %v, want the error reply", err)
+	}
+
+	s.setFail("DEL", "READONLY You can't write against a read only replica.")
+	if err := r.Delete("key"); err == nil || !strings.Contains(err.Error(), "READONLY") {
+		t.Fatalf("Delete error = %v, want the error reply", err)
+	}
+
+	// An error reply leaves the connection usable.
+	s.setFail("SET", "")
+	s.setFail("GET", "")
+	if err := r.SetExpires("key", time.Now().Add(time.Minute), testCache(16)); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := r.Get("key"); err != nil || got == nil {
+		t.Fatalf("Get = %+v, %v", got, err)
+	}
+
+	s.mu.Lock()
+	dials := s.dials
+	s.mu.Unlock()
+	if dials != 1 {
+		t.Errorf("%d connections dialed, want 1", dials)
+	}
+}
@@ -0,0 +1,283 @@
+package repo
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultRedisMaxIdleConns = 2
+
+// redisError is an error reply (-ERR ...) sent by the server. The connection stays usable.
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+type redisConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+}
+
+// redisPool is a minimal RESP2 client keeping up to maxIdle connections around.
+type redisPool struct {
+	address  string
+	username string
+	password string
+	db       int
+	timeout  time.Duration
+	maxIdle  int
+
+	mu   sync.Mutex
+	idle []*redisConn
+}
+
+func (p *redisPool) do(args ...any) (any, error) {
+	replies, err := p.pipeline(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if err, ok := replies[0].(redisError); ok {
+		return nil, err
+	}
+
+	return replies[0], nil
+}
+
+// pipeline sends the commands at once and returns one reply per command, error replies
+// being returned as redisError values.
+func (p *redisPool) pipeline(cmds ...[]any) ([]any, error) {
+	cn, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+
+	replies, err := cn.pipeline(p.timeout, cmds...)
+	if err != nil {
+		cn.conn.Close()
+		return nil, err
+	}
+
+	p.put(cn)
+
+	return replies, nil
+}
+
+func (p *redisPool) get() (*redisConn, error) {
+	p.mu.Lock()
+	if n := len(p.idle); n > 0 {
+		cn := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return cn, nil
+	}
+	p.mu.Unlock()
+
+	return p.dial()
+}
+
+func (p *redisPool) put(cn *redisConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.idle) >= p.maxIdle {
+		cn.conn.Close()
+		return
+	}
+
+	p.idle = append(p.idle, cn)
+}
+
+func (p *redisPool) dial() (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", p.address, p.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	cn := &redisConn{
+		conn: conn,
+		rw:   bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)),
+	}
+
+	if p.password != "" {
+		args := []any{[]byte("AUTH"), []byte(p.password)}
+		if p.username != "" {
+			args = []any{[]byte("AUTH"), []byte(p.username), []byte(p.password)}
+		}
+
+		if _, err := cn.do(p.timeout, args...); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("auth: %v", err)
+		}
+	}
+
+	if p.db != 0 {
+		if _, err := cn.do(p.timeout, []byte("SELECT"), []byte(strconv.Itoa(p.db))); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("select db %d: %v", p.db, err)
+		}
+	}
+
+	return cn, nil
+}
+
+func (cn *redisConn) do(timeout time.Duration, args ...any) (any, error) {
+	replies, err := cn.pipeline(timeout, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if err, ok := replies[0].(redisError); ok {
+		return nil, err
+	}
+
+	return replies[0], nil
+}
+
+func (cn *redisConn) pipeline(timeout time.Duration, cmds ...[]any) ([]any, error) {
+	if err := cn.conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	for _, args := range cmds {
+		if err := writeCommand(cn.rw.Writer, args); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cn.rw.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]any, len(cmds))
+	for i := range replies {
+		reply, err := readReply(cn.rw.Reader)
+		if err != nil {
+			if _, ok := err.(redisError); !ok {
+				return nil, err
+			}
+			reply = err
+		}
+		replies[i] = reply
+	}
+
+	return replies, nil
+}
+
+// writeCommand writes the command as an array of bulk strings. An argument is either a
+// []byte or a [][]byte written piece by piece, so that a large body is streamed after its
+// metadata instead of being concatenated with it.
+func writeCommand(w *bufio.Writer, args []any) error {
+	if _, err := fmt.Fprintf(w, "*%d\r\n", len(args)); err != nil {
+		return err
+	}
+
+	for _, arg := range args {
+		var pieces [][]byte
+		switch a := arg.(type) {
+		case []byte:
+			pieces = [][]byte{a}
+		case [][]byte:
+			pieces = a
+		default:
+			return fmt.Errorf("redis: unsupported argument type %T", arg)
+		}
+
+		size := 0
+		for _, piece := range pieces {
+			size += len(piece)
+		}
+
+		if _, err := fmt.Fprintf(w, "$%d\r\n", size); err != nil {
+			return err
+		}
+		for _, piece := range pieces {
+			if _, err := w.Write(piece); err != nil {
+				return err
+			}
+		}
+		if _, err := w.WriteString("\r\n"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readReply decodes one RESP2 reply: string, redisError, int64, []byte (nil for a nil bulk) or []any.
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) == 0 {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return string(line[1:]), nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(string(line[1:]), 10, 64)
+	case '$':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid array length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				if _, ok := err.(redisError); !ok {
+					return nil, err
+				}
+				items[i] = err
+			}
+		}
+
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
+
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: invalid line %q", line)
+	}
+
+	return line[:len(line)-2], nil
+}
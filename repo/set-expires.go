@@ -1,7 +1,6 @@
 package repo
 
 import (
-	"encoding/json"
 	"fmt"
 	"time"
 
@@ -10,9 +9,11 @@ import (
 )
 
 func (r *repoManager) SetExpires(key string, t time.Time, data model.Cache) error {
-	b, err := json.Marshal(data)
-	if err != nil {
-		return fmt.Errorf("Marshal cache data error: %v", err)
+	b, compressed := r.compressor.compress(marshalCache(data))
+
+	var flags uint32
+	if compressed {
+		flags |= flagGzip
 	}
 
 	expiration := int32(t.Unix() - time.Now().Unix())
@@ -20,9 +21,18 @@ func (r *repoManager) SetExpires(key string, t time.Time, data model.Cache) erro
 		return nil
 	}
 
-	err = r.db.Set(&memcache.Item{
+	if len(b) > r.chunkSize {
+		if err := r.setChunked(key, b, flags, expiration); err != nil {
+			return fmt.Errorf("Set data to memcached error: %v", err)
+		}
+
+		return nil
+	}
+
+	err := r.db.Set(&memcache.Item{
 		Key:        key,
 		Value:      b,
+		Flags:      flags,
 		Expiration: expiration,
 	})
 
@@ -0,0 +1,44 @@
+package traefik_cache
+
+import (
+	"net/http"
+
+	"github.com/ghnexpress/traefik-cache/model"
+)
+
+// notUpdatedHeaders are kept from the stored response when a 304 refreshes it.
+var notUpdatedHeaders = []string{"Content-Length", "Content-Encoding", "Transfer-Encoding", "Content-Range"}
+
+// setValidators turns req into a conditional request for the stale entry, replacing
+// the client's own preconditions which do not apply to the stored response.
+func setValidators(req *http.Request, stale *model.Cache) {
+	req.Header.Del("If-None-Match")
+	req.Header.Del("If-Modified-Since")
+
+	if stale.ETag != "" {
+		req.Header.Set("If-None-Match", stale.ETag)
+	}
+
+	if stale.LastModified != "" {
+		req.Header.Set("If-Modified-Since", stale.LastModified)
+	}
+}
+
+// refresh stores the stale entry again with the headers of the 304 response, without
+// transferring the body. It returns nil when the refreshed entry is no longer cacheable.
+func (c *Cache) refresh(req *http.Request, requestID, key string, stale *model.Cache, header http.Header) *model.Cache {
+	merged := http.Header(stale.Headers).Clone()
+	for k, vals := range header {
+		merged[k] = vals
+	}
+
+	for _, k := range notUpdatedHeaders {
+		if vals, ok := stale.Headers[k]; ok {
+			merged[k] = vals
+		} else {
+			merged.Del(k)
+		}
+	}
+
+	return c.store(req, requestID, key, stale.Status, merged, stale.Body)
+}
@@ -0,0 +1,137 @@
+package traefik_cache
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	defaultCacheableMethods = []string{http.MethodGet, http.MethodHead}
+	// defaultCacheableStatus are the status codes heuristically cacheable (RFC 9110 §15.1),
+	// used by forced caching when cacheable.status is not configured.
+	defaultCacheableStatus = []int{200, 203, 204, 300, 301, 308, 404, 405, 410, 414, 501}
+)
+
+// statusRule is one entry of cacheable.status: an exact code or a class such as 5xx,
+// with its TTL. Without forced caching, the TTL overrides the lifetime of the responses
+// the headers allow to store. never marks the status as not cacheable.
+type statusRule struct {
+	code  int
+	class int
+	ttl   time.Duration
+	never bool
+}
+
+// parseMethods parses the comma-separated cacheable.methods, GET and HEAD by default.
+func parseMethods(methods string) []string {
+	if strings.TrimSpace(methods) == "" {
+		return defaultCacheableMethods
+	}
+
+	var parsed []string
+	for _, method := range strings.Split(methods, ",") {
+		if method = strings.ToUpper(strings.TrimSpace(method)); method != "" {
+			parsed = append(parsed, method)
+		}
+	}
+
+	return parsed
+}
+
+// parseStatusRules parses cacheable.status, e.g. "200:300s,404:30s,5xx:never". A TTL
+// without unit is in seconds.
+func parseStatusRules(rules string) ([]statusRule, error) {
+	var parsed []statusRule
+
+	for _, raw := range strings.Split(rules, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		parts := strings.SplitN(raw, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid status rule %q", raw)
+		}
+
+		var rule statusRule
+		status := strings.ToLower(strings.TrimSpace(parts[0]))
+		if len(status) == 3 && strings.HasSuffix(status, "xx") && status[0] >= '1' && status[0] <= '5' {
+			rule.class = int(status[0] - '0')
+		} else if code, err := strconv.Atoi(status); err == nil && code >= 100 && code <= 599 {
+			rule.code = code
+		} else {
+			return nil, fmt.Errorf("invalid status %q in rule %q", parts[0], raw)
+		}
+
+		ttl := strings.TrimSpace(parts[1])
+		switch {
+		case ttl == "never":
+			rule.never = true
+		default:
+			if seconds, err := strconv.Atoi(ttl); err == nil {
+				rule.ttl = time.Duration(seconds) * time.Second
+			} else if d, err := time.ParseDuration(ttl); err == nil {
+				rule.ttl = d
+			} else {
+				return nil, fmt.Errorf("invalid ttl %q in rule %q", parts[1], raw)
+			}
+
+			rule.never = rule.ttl <= 0
+		}
+
+		parsed = append(parsed, rule)
+	}
+
+	return parsed, nil
+}
+
+func (c *Cache) methodCacheable(method string) bool {
+	for _, m := range c.methods {
+		if m == method {
+			return true
+		}
+	}
+
+	return false
+}
+
+// statusRule returns the rule of the status, exact codes taking precedence over classes.
+func (c *Cache) statusRule(status int) (statusRule, bool) {
+	for _, rule := range c.statusRules {
+		if rule.code == status {
+			return rule, true
+		}
+	}
+
+	for _, rule := range c.statusRules {
+		if rule.class != 0 && rule.class == status/100 {
+			return rule, true
+		}
+	}
+
+	return statusRule{}, false
+}
+
+// statusCacheable tells whether responses with the status may be stored, forced or not.
+func (c *Cache) statusCacheable(status int) bool {
+	if len(c.statusRules) > 0 {
+		rule, ok := c.statusRule(status)
+		return ok && !rule.never
+	}
+
+	if !c.config.ForceCache.Enable {
+		return true
+	}
+
+	for _, s := range defaultCacheableStatus {
+		if s == status {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,82 @@
+package traefik_cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseStatusRules(t *testing.T) {
+	tests := []struct {
+		rules   string
+		want    []statusRule
+		wantErr bool
+	}{
+		{rules: "", want: nil},
+		{rules: "200:300s, 404:30, 5xx:never", want: []statusRule{
+			{code: 200, ttl: 300 * time.Second},
+			{code: 404, ttl: 30 * time.Second},
+			{class: 5, never: true},
+		}},
+		{rules: "301:0", want: []statusRule{{code: 301, never: true}}},
+		{rules: "200", wantErr: true},
+		{rules: "6xx:10s", wantErr: true},
+		{rules: "200:soon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseStatusRules(tt.rules)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseStatusRules(%q) error = %v, wantErr %v", tt.rules, err, tt.wantErr)
+			continue
+		}
+
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseStatusRules(%q) = %+v, want %+v", tt.rules, got, tt.want)
+		}
+	}
+}
+
+func TestExpirationStatusRule(t *testing.T) {
+	rules, err := parseStatusRules("200:300s,404:30s,5xx:never")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		forceCache   bool
+		status       int
+		cacheControl string
+		wantOK       bool
+		wantTTL      time.Duration
+	}{
+		{name: "rule overrides max-age", status: 200, cacheControl: "max-age=60", wantOK: true, wantTTL: 300 * time.Second},
+		{name: "rule shortens max-age", status: 404, cacheControl: "max-age=600", wantOK: true, wantTTL: 30 * time.Second},
+		{name: "headers still forbid storing", status: 200, cacheControl: "no-store"},
+		{name: "never", status: 503, cacheControl: "max-age=60"},
+		{name: "status without rule", status: 301, cacheControl: "max-age=60"},
+		{name: "forced", forceCache: true, status: 200, cacheControl: "no-store", wantOK: true, wantTTL: 300 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Cache{statusRules: rules}
+			c.config.ForceCache.Enable = tt.forceCache
+
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			header := http.Header{"Cache-Control": {tt.cacheControl}}
+
+			expires, ok := c.expiration(req, tt.status, header)
+			if ok != tt.wantOK {
+				t.Fatalf("expiration ok = %v, want %v", ok, tt.wantOK)
+			}
+
+			if ttl := time.Until(expires); ok && (ttl > tt.wantTTL || ttl < tt.wantTTL-time.Second) {
+				t.Errorf("expiration in %v, want %v", ttl, tt.wantTTL)
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package traefik_cache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/ghnexpress/traefik-cache/constants"
+	"github.com/ghnexpress/traefik-cache/model"
+	"github.com/ghnexpress/traefik-cache/tracing"
+	"github.com/pquerna/cachecontrol/cacheobject"
+)
+
+// staleWindows returns the stale-while-revalidate and stale-if-error windows in seconds,
+// taken from the response Cache-Control extensions or else from the configuration.
+func (c *Cache) staleWindows(header http.Header) (int64, int64) {
+	whileRevalidate, ifError := int64(c.config.Stale.WhileRevalidate), int64(c.config.Stale.IfError)
+
+	cc, err := cacheobject.ParseResponseCacheControl(header.Get("Cache-Control"))
+	if err != nil {
+		return 0, 0
+	}
+
+	// The origin asked every use of a stale response to be revalidated.
+	if cc.NoCachePresent || cc.MustRevalidate || cc.ProxyRevalidate {
+		whileRevalidate, ifError = 0, 0
+	}
+
+	if cc.StaleWhileRevalidate >= 0 {
+		whileRevalidate = int64(cc.StaleWhileRevalidate)
+	}
+
+	if cc.StaleIfError >= 0 {
+		ifError = int64(cc.StaleIfError)
+	}
+
+	return whileRevalidate, ifError
+}
+
+// revalidate refreshes the stale entry in the background, once per key at a time.
+func (c *Cache) revalidate(req *http.Request, requestID, key string, stale *model.Cache) {
+	flight := variantKey(key, variantNames(stale.Headers), req)
+	cl, leader := c.coalescer.join(flight)
+	if !leader {
+		return
+	}
+
+	bg, err := detach(req)
+	if err != nil {
+		c.coalescer.finish(flight, cl, nil)
+		c.log.Alert(requestID, fmt.Errorf("Detach request for revalidation error: %v", err))
+		return
+	}
+
+	setValidators(bg, stale)
+
+	go func() {
+		var value *model.Cache
+		defer func() { c.coalescer.finish(flight, cl, value) }()
+
+		bw := newBufferWriter()
+		bw.maxBodySize = c.maxBodySize()
+		if err := c.serveNext(bw, bg); err != nil {
+			c.log.Alert(requestID, err)
+			return
+		}
+
+		value = c.update(bg, requestID, key, stale, bw)
+	}()
+}
+
+// fetchStale revalidates the stale entry with the upstream before answering. Within the
+// stale-if-error window, the entry is served when the upstream answers with a server
+// error or does not answer within stale.timeout. It returns the refreshed value, nil when
+// the entry was not refreshed.
+func (c *Cache) fetchStale(rw http.ResponseWriter, req *http.Request, requestID, key string, stale *model.Cache) *model.Cache {
+	ifError := stale.CanStaleIfError(time.Now())
+
+	bg, err := detach(req)
+	if err != nil {
+		c.log.Alert(requestID, fmt.Errorf("Detach request for revalidation error: %v", err))
+
+		if ifError {
+			c.serveCache(rw, req, requestID, key, stale, staleStatus(stale))
+			return nil
+		}
+
+		return c.fetch(rw, req, requestID, key, fwdStale)
+	}
+
+	setValidators(bg, stale)
+
+	// Only a 304, or a server error within the stale-if-error window, is held back: any
+	// other response is streamed to the client while being captured, as by fetch.
+	r := newResponseWriter(rw)
+	r.maxBodySize = c.maxBodySize()
+	r.beforeWriteHeader = func(status int) {
+		st := cacheStatus{status: constants.BypassCacheStatus, fwd: fwdStale, fwdStatus: status}
+		if c.statusCacheable(status) {
+			if contentLength(r.Header()) > r.maxBodySize {
+				r.truncated = true
+			} else {
+				st.status = constants.MissCacheStatus
+				st.stored = c.storable(req, status, r.Header())
+			}
+		}
+
+		c.setCacheStatus(rw.Header(), req, key, st)
+	}
+
+	bw := newRevalidationWriter(r, req.Method != http.MethodHead, func(status int) bool {
+		return status == http.StatusNotModified || ifError && status >= http.StatusInternalServerError
+	})
+	// Past a timeout, the whole response is captured for the background refresh.
+	bw.maxBodySize = c.maxBodySize()
+	done := make(chan error, 1)
+	go func() {
+		err := c.serveNext(bw, bg)
+		if err == nil && !bw.decided {
+			bw.WriteHeader(http.StatusOK)
+		}
+		done <- err
+	}()
+
+	var timeout <-chan time.Time
+	if ifError && c.config.Stale.Timeout > 0 {
+		timer := time.NewTimer(time.Duration(c.config.Stale.Timeout) * time.Second)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case err = <-done:
+	case <-timeout:
+		if !bw.abandon() {
+			// The response is already being streamed to the client.
+			err = <-done
+			break
+		}
+
+		c.serveCache(rw, req, requestID, key, stale, cacheStatus{
+			status: constants.StaleCacheStatus,
+			fwd:    fwdStale,
+			value:  stale,
+			detail: "upstream timeout",
+		})
+
+		// Let the slow upstream response refresh the entry once it arrives.
+		go func() {
+			if err := <-done; err == nil {
+				c.update(bg, requestID, key, stale, bw.bufferWriter)
+			}
+		}()
+		return nil
+	}
+
+	if err != nil {
+		c.log.Alert(requestID, err)
+	}
+
+	if bw.streaming {
+		switch {
+		case err != nil:
+			return nil
+		case r.truncated:
+			decisionOf(req).addReason("body larger than maxBodySize")
+			return nil
+		default:
+			return c.store(bg, requestID, key, bw.status, r.Header().Clone(), r.body)
+		}
+	}
+
+	if ifError && (err != nil || bw.status >= http.StatusInternalServerError) {
+		st := cacheStatus{status: constants.StaleCacheStatus, fwd: fwdStale, value: stale, detail: "upstream error"}
+		if err == nil {
+			st.fwdStatus = bw.status
+		}
+
+		c.serveCache(rw, req, requestID, key, stale, st)
+		return nil
+	}
+
+	if err != nil {
+		c.setCacheStatus(rw.Header(), req, key, cacheStatus{status: constants.ErrorCacheStatus, fwd: fwdStale, detail: "upstream error"})
+		rw.WriteHeader(http.StatusBadGateway)
+		return nil
+	}
+
+	// Only a 304 is left.
+	value := c.update(bg, requestID, key, stale, bw.bufferWriter)
+	served := value
+	if served == nil {
+		served = stale
+	}
+
+	c.serveCache(rw, req, requestID, key, served, cacheStatus{
+		status:    constants.HitCacheStatus,
+		fwd:       fwdStale,
+		fwdStatus: bw.status,
+		value:     served,
+	})
+
+	return value
+}
+
+// update stores the upstream answer to a revalidation: a 304 refreshes the stale entry,
+// a server error leaves it untouched.
+func (c *Cache) update(req *http.Request, requestID, key string, stale *model.Cache, bw *bufferWriter) *model.Cache {
+	switch {
+	case bw.truncated:
+		return nil
+	case bw.status == http.StatusNotModified:
+		return c.refresh(req, requestID, key, stale, bw.header)
+	case bw.status < http.StatusInternalServerError:
+		return c.store(req, requestID, key, bw.status, bw.header, bw.body)
+	default:
+		return nil
+	}
+}
+
+// serveNext calls the upstream outside of the client request, turning a panic into an error.
+func (c *Cache) serveNext(rw http.ResponseWriter, req *http.Request) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Upstream panic: %v", r)
+		}
+	}()
+
+	c.upstream(rw, req)
+
+	return nil
+}
+
+// detach clones req so that it can be sent upstream after the client request ended. A HEAD
+// request is turned into the GET request whose response is stored, and only codings the
+// cache can decode are accepted.
+func detach(req *http.Request) (*http.Request, error) {
+	// The background request stays in the trace, and decision, of the client request.
+	ctx := tracing.ContextWithSpan(context.Background(), tracing.SpanFromContext(req.Context()))
+	bg := req.Clone(withDecision(ctx, decisionOf(req)))
+	if bg.Method == http.MethodHead {
+		bg.Method = http.MethodGet
+	}
+	restrictAcceptEncoding(bg.Header)
+	if req.Body == nil || req.Body == http.NoBody {
+		return bg, nil
+	}
+
+	bodyBytes, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
+	bg.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
+
+	return bg, nil
+}
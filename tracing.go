@@ -0,0 +1,124 @@
+package traefik_cache
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ghnexpress/traefik-cache/log"
+	"github.com/ghnexpress/traefik-cache/model"
+	"github.com/ghnexpress/traefik-cache/tracing"
+)
+
+const (
+	defaultTracingServiceName = "traefik-cache"
+	defaultTracingSampleRate  = 100 // percent
+	defaultTracingTimeout     = 5   // second
+)
+
+var (
+	tracers      = make(map[model.Tracing]*tracing.Tracer)
+	tracersMutex = sync.Mutex{}
+)
+
+// getTracer shares one tracer, and its exporter, between all the middlewares using the same
+// tracing configuration. It returns nil, a no-op tracer, when no endpoint is configured.
+func getTracer(cfg model.Tracing, logger log.Log) *tracing.Tracer {
+	if cfg.Endpoint == "" {
+		return nil
+	}
+
+	tracersMutex.Lock()
+	defer tracersMutex.Unlock()
+
+	if tracer, ok := tracers[cfg]; ok {
+		return tracer
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = defaultTracingServiceName
+	}
+
+	sampleRate := cfg.SampleRate
+	if sampleRate <= 0 {
+		sampleRate = defaultTracingSampleRate
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultTracingTimeout
+	}
+
+	exporter := tracing.NewExporter(cfg.Endpoint, serviceName, time.Duration(timeout)*time.Second, func(err error) {
+		logger.Warn("Export spans error", log.Fields{"error": err})
+	})
+	tracer := tracing.NewTracer(exporter, float64(sampleRate)/100)
+	tracers[cfg] = tracer
+
+	return tracer
+}
+
+// startTrace starts the server span of the request, continuing the incoming traceparent.
+// The returned request carries the span, nil when the request is not traced.
+func (c *Cache) startTrace(req *http.Request) (*http.Request, *tracing.Span) {
+	remote, _ := tracing.ParseTraceparent(req.Header.Get("traceparent"))
+
+	ctx, span := c.tracer.Start(req.Context(), "cache "+req.Method, tracing.KindServer, remote)
+	if span == nil {
+		return req, nil
+	}
+
+	span.SetAttributes(
+		tracing.String("http.method", req.Method),
+		tracing.String("http.target", req.URL.RequestURI()),
+		tracing.String("net.host.name", req.Host),
+		tracing.String("traefik.router", c.name),
+	)
+
+	return req.WithContext(ctx), span
+}
+
+// startSpan starts a child of the request span, nil when the request is not traced.
+func (c *Cache) startSpan(req *http.Request, name string, kind tracing.SpanKind) *tracing.Span {
+	if tracing.SpanFromContext(req.Context()) == nil {
+		return nil
+	}
+
+	_, span := c.tracer.Start(req.Context(), name, kind, tracing.SpanContext{})
+
+	return span
+}
+
+// upstream calls the next handler within a client span, propagated with the traceparent header.
+func (c *Cache) upstream(rw http.ResponseWriter, req *http.Request) {
+	start := time.Now()
+	defer decisionOf(req).update(func(d *decision) { d.upstreamDuration += time.Since(start) })
+
+	span := c.startSpan(req, "upstream", tracing.KindClient)
+	if span == nil {
+		c.next.ServeHTTP(rw, req)
+		return
+	}
+	defer span.End()
+
+	ctx := tracing.ContextWithSpan(req.Context(), span)
+	req = req.Clone(ctx)
+	req.Header.Set("traceparent", span.Context().Traceparent())
+
+	c.next.ServeHTTP(rw, req)
+
+	status := 0
+	switch w := rw.(type) {
+	case *ResponseWriter:
+		status = w.status
+	case *bufferWriter:
+		status = w.status
+	case *revalidationWriter:
+		status = w.status
+	}
+
+	if status != 0 {
+		span.SetAttributes(tracing.Int("http.status_code", status))
+	}
+}
@@ -0,0 +1,230 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+type SpanKind int
+
+// Span kinds, numbered as in OTLP.
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// SpanContext is the W3C trace context of a span.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// ParseTraceparent decodes a traceparent header, version 00 or a later one read as 00.
+func ParseTraceparent(h string) (SpanContext, bool) {
+	var sc SpanContext
+
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, false
+	}
+
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+
+	var flags [1]byte
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+
+	return sc, sc.IsValid()
+}
+
+// Traceparent encodes the context as a version 00 traceparent header.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: int64(value)}
+}
+
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Span is a recorded operation. A nil *Span is valid and records nothing, which is what
+// a tracer without exporter or an unsampled trace hands out.
+type Span struct {
+	tracer   *Tracer
+	name     string
+	kind     SpanKind
+	context  SpanContext
+	parentID [8]byte
+	start    time.Time
+	end      time.Time
+
+	mu       sync.Mutex
+	attrs    []Attribute
+	errorMsg string
+	ended    bool
+}
+
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+
+	return s.context
+}
+
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.attrs = append(s.attrs, attrs...)
+	s.mu.Unlock()
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.errorMsg = err.Error()
+	s.mu.Unlock()
+}
+
+// End records the span end time and hands it to the exporter, once.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	s.tracer.exporter.export(s)
+}
+
+type spanKey struct{}
+
+// SpanFromContext returns the span carried by ctx, nil if none.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	if s == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// Tracer starts spans and hands the sampled ones to its exporter. A nil *Tracer is a no-op.
+type Tracer struct {
+	exporter   *Exporter
+	sampleRate float64
+}
+
+// NewTracer returns a tracer exporting to exporter, nil (a no-op) without exporter. Root
+// spans are sampled at sampleRate, between 0 and 1; child spans follow their parent.
+func NewTracer(exporter *Exporter, sampleRate float64) *Tracer {
+	if exporter == nil {
+		return nil
+	}
+
+	return &Tracer{exporter: exporter, sampleRate: sampleRate}
+}
+
+// Start starts a span child of the span in ctx, or of remote when ctx has none and
+// remote is valid, or else a root span.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind, remote SpanContext) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	s := &Span{tracer: t, name: name, kind: kind, start: time.Now()}
+
+	switch parent := SpanFromContext(ctx); {
+	case parent != nil:
+		s.context.TraceID = parent.context.TraceID
+		s.parentID = parent.context.SpanID
+	case remote.IsValid():
+		if !remote.Sampled {
+			return ctx, nil
+		}
+
+		s.context.TraceID = remote.TraceID
+		s.parentID = remote.SpanID
+	default:
+		if randomFloat() >= t.sampleRate {
+			return ctx, nil
+		}
+
+		randomBytes(s.context.TraceID[:])
+	}
+
+	randomBytes(s.context.SpanID[:])
+	s.context.Sampled = true
+
+	return ContextWithSpan(ctx, s), s
+}
+
+func randomBytes(b []byte) {
+	rand.Read(b)
+}
+
+func randomFloat() float64 {
+	var b [8]byte
+	randomBytes(b[:])
+
+	return float64(binary.BigEndian.Uint64(b[:])>>11) / (1 << 53)
+}
@@ -0,0 +1,242 @@
+package traefik_cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghnexpress/traefik-cache/constants"
+	"github.com/ghnexpress/traefik-cache/model"
+	"github.com/ghnexpress/traefik-cache/utils"
+)
+
+func isUnsafe(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	default:
+		return true
+	}
+}
+
+// serveUnsafe passes an unsafe request through without caching it and, when it succeeded,
+// invalidates the request URI, the Location and Content-Location URIs on the same host and
+// the related paths of invalidateOnUnsafe.rules (RFC 9111 §4.4).
+func (c *Cache) serveUnsafe(rw http.ResponseWriter, req *http.Request, requestID string) {
+	// Only the status and headers are needed, the body is not captured.
+	r := newResponseWriter(rw)
+	r.truncated = true
+	r.beforeWriteHeader = func(int) {
+		c.setCacheStatus(rw.Header(), req, "", cacheStatus{status: constants.BypassCacheStatus, fwd: fwdMethod})
+	}
+
+	c.upstream(r, req)
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+
+	if r.status >= http.StatusBadRequest {
+		return
+	}
+
+	now := time.Now().UnixNano()
+	bans := []model.Ban{{Type: string(constants.URLBanType), Value: req.URL.RequestURI(), CreatedAt: now}}
+
+	for _, name := range []string{"Location", "Content-Location"} {
+		location := r.Header().Get(name)
+		if location == "" {
+			continue
+		}
+
+		u, err := req.URL.Parse(location)
+		if err != nil || (u.Host != "" && u.Host != req.Host) {
+			continue
+		}
+
+		bans = append(bans, model.Ban{Type: string(constants.URLBanType), Value: u.RequestURI(), CreatedAt: now})
+	}
+
+	for _, prefix := range relatedPaths(c.unsafeRules, req.URL.Path) {
+		bans = append(bans, model.Ban{Type: string(constants.PrefixBanType), Value: prefix, CreatedAt: now})
+	}
+
+	if err := c.setUnsafeBans(req.Host, bans...); err != nil {
+		c.log.Alert(requestID, fmt.Errorf("Invalidate on %s error: %v", req.Method, err))
+	}
+}
+
+// The bans of unsafe requests only target their URIs and the configured related prefixes,
+// so each is stored under its own key, replacing the previous ban of the same target,
+// instead of being appended to the ban list of the host: nothing grows with the number of
+// writes, and concurrent writes from other instances cannot drop each other's bans.
+// Lookups remember the ban of each target, or its absence, for banRefreshInterval.
+
+const maxUnsafeBans = 10000
+
+type unsafeBan struct {
+	createdAt int64
+	checked   time.Time
+}
+
+type unsafeBanCache struct {
+	mu   sync.Mutex
+	bans map[string]unsafeBan
+}
+
+func newUnsafeBanCache() *unsafeBanCache {
+	return &unsafeBanCache{bans: make(map[string]unsafeBan)}
+}
+
+// get returns the creation time of the ban under key, 0 when there is none, and whether it
+// was checked recently enough.
+func (b *unsafeBanCache) get(key string) (int64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ban, ok := b.bans[key]
+	if !ok || time.Since(ban.checked) >= banRefreshInterval {
+		return 0, false
+	}
+
+	return ban.createdAt, true
+}
+
+func (b *unsafeBanCache) set(key string, createdAt int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if len(b.bans) >= maxUnsafeBans {
+		for k, ban := range b.bans {
+			if now.Sub(ban.checked) >= banRefreshInterval {
+				delete(b.bans, k)
+			}
+		}
+
+		if len(b.bans) >= maxUnsafeBans {
+			b.bans = make(map[string]unsafeBan)
+		}
+	}
+
+	b.bans[key] = unsafeBan{createdAt: createdAt, checked: now}
+}
+
+func unsafeBanKey(host string, ban model.Ban) string {
+	return utils.GetMD5Hash([]byte(fmt.Sprintf("bans|%s|%s|%s", host, ban.Type, ban.Value)))
+}
+
+func (c *Cache) setUnsafeBans(host string, bans ...model.Ban) error {
+	retention := c.banRetention()
+
+	for _, ban := range bans {
+		body, err := json.Marshal(ban)
+		if err != nil {
+			return fmt.Errorf("Marshal ban error: %v", err)
+		}
+
+		key := unsafeBanKey(host, ban)
+		if err := c.cacheRepo.SetExpires(key, time.Now().Add(retention), model.Cache{Body: body}); err != nil {
+			return err
+		}
+
+		c.unsafeBans.set(key, ban.CreatedAt)
+	}
+
+	return nil
+}
+
+// unsafeBanned reports whether an unsafe request on the entry URI, or on a related prefix
+// of it, succeeded after the entry was stored.
+func (c *Cache) unsafeBanned(host string, value *model.Cache) (bool, error) {
+	targets := []model.Ban{{Type: string(constants.URLBanType), Value: value.URL}}
+	for _, rule := range c.unsafeRules {
+		for _, prefix := range rule.related {
+			if strings.HasPrefix(value.URL, prefix) {
+				targets = append(targets, model.Ban{Type: string(constants.PrefixBanType), Value: prefix})
+			}
+		}
+	}
+
+	for _, target := range targets {
+		createdAt, err := c.unsafeBanCreatedAt(unsafeBanKey(host, target))
+		if err != nil {
+			return false, err
+		}
+
+		if createdAt > value.StoredAt {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// unsafeBanCreatedAt returns the creation time of the ban stored under key, 0 when there
+// is none.
+func (c *Cache) unsafeBanCreatedAt(key string) (int64, error) {
+	if createdAt, ok := c.unsafeBans.get(key); ok {
+		return createdAt, nil
+	}
+
+	stored, err := c.cacheRepo.Get(key)
+	if err != nil {
+		return 0, err
+	}
+
+	var ban model.Ban
+	if stored != nil {
+		if err := json.Unmarshal(stored.Body, &ban); err != nil {
+			return 0, fmt.Errorf("Unmarshal ban error: %v", err)
+		}
+	}
+
+	c.unsafeBans.set(key, ban.CreatedAt)
+
+	return ban.CreatedAt, nil
+}
+
+// unsafeRule maps the path prefix of unsafe requests to the path prefixes they invalidate.
+type unsafeRule struct {
+	prefix  string
+	related []string
+}
+
+// parseUnsafeRules parses comma-separated "prefix=related1|related2" entries, e.g.
+// "/orders/=/orders|/customers".
+func parseUnsafeRules(rules string) []unsafeRule {
+	var parsed []unsafeRule
+
+	for _, rule := range strings.Split(rules, ",") {
+		parts := strings.SplitN(strings.TrimSpace(rule), "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			continue
+		}
+
+		r := unsafeRule{prefix: parts[0]}
+		for _, p := range strings.Split(parts[1], "|") {
+			if p = strings.TrimSpace(p); p != "" {
+				r.related = append(r.related, p)
+			}
+		}
+
+		parsed = append(parsed, r)
+	}
+
+	return parsed
+}
+
+// relatedPaths returns the path prefixes to invalidate for path.
+func relatedPaths(rules []unsafeRule, path string) []string {
+	var related []string
+
+	for _, rule := range rules {
+		if strings.HasPrefix(path, rule.prefix) {
+			related = append(related, rule.related...)
+		}
+	}
+
+	return related
+}
@@ -0,0 +1,104 @@
+package traefik_cache
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ghnexpress/traefik-cache/model"
+	"github.com/ghnexpress/traefik-cache/utils"
+)
+
+// lookup returns the entry for the request. When the primary key holds the variant index
+// of a response with a Vary header, the variant selected by the request headers is
+// returned along with the index header names.
+func (c *Cache) lookup(req *http.Request, key string) (*model.Cache, []string, error) {
+	value, err := c.cacheRepo.Get(key)
+	if err != nil || value == nil || len(value.VaryIndex) == 0 {
+		return value, nil, err
+	}
+
+	names := value.VaryIndex
+	value, err = c.cacheRepo.Get(variantKey(key, names, req))
+
+	return value, names, err
+}
+
+// varyNames returns the canonical request header names listed by the Vary response header.
+func varyNames(header http.Header) []string {
+	var names []string
+	seen := make(map[string]bool)
+
+	for _, v := range header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+
+			if name != "*" {
+				name = http.CanonicalHeaderKey(name)
+			}
+
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	return names
+}
+
+// variantNames returns the Vary header names selecting a stored variant. Accept-Encoding is
+// left out: one identity representation is stored for every encoding, see encodeBody.
+func variantNames(header http.Header) []string {
+	return removeName(varyNames(header), "Accept-Encoding")
+}
+
+// addVary adds the comma-separated names to the Vary header, skipping those already listed.
+func addVary(header http.Header, names string) {
+	listed := varyNames(header)
+	for _, name := range varyNames(http.Header{"Vary": {names}}) {
+		if !containsName(listed, name) {
+			header.Add("Vary", name)
+			listed = append(listed, name)
+		}
+	}
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func removeName(names []string, name string) []string {
+	var kept []string
+	for _, n := range names {
+		if n != name {
+			kept = append(kept, n)
+		}
+	}
+
+	return kept
+}
+
+// variantKey is the secondary key of the variant selected by the request values of the
+// Vary header names. It is the primary key itself when there is no Vary header.
+func variantKey(key string, names []string, req *http.Request) string {
+	if len(names) == 0 {
+		return key
+	}
+
+	raw := key
+	for _, name := range names {
+		raw = fmt.Sprintf("%s|%s=%s", raw, name, strings.Join(req.Header.Values(name), ","))
+	}
+
+	return utils.GetMD5Hash([]byte(raw))
+}
@@ -1,23 +1,202 @@
 package traefik_cache
 
-import "net/http"
+import (
+	"net/http"
+	"sync"
+)
 
+// ResponseWriter streams the upstream response to the client while capturing it. Its
+// headers are kept apart from the client response headers so that the headers added by
+// outer middlewares (e.g. the Content-Encoding of a compress middleware) are not stored.
 type ResponseWriter struct {
 	http.ResponseWriter
-	status int
-	body   []byte
+	header      http.Header
+	status      int
+	body        []byte
+	wroteHeader bool
+	// maxBodySize caps the captured body, the response being streamed to the client
+	// regardless. truncated is set once the cap is exceeded.
+	maxBodySize int
+	truncated   bool
+	// beforeWriteHeader may still change the client response headers once the status is known.
+	beforeWriteHeader func(status int)
+}
+
+func newResponseWriter(rw http.ResponseWriter) *ResponseWriter {
+	return &ResponseWriter{ResponseWriter: rw, header: make(http.Header)}
 }
 
 func (rw *ResponseWriter) Header() http.Header {
-	return rw.ResponseWriter.Header()
+	return rw.header
 }
 
 func (rw *ResponseWriter) Write(p []byte) (int, error) {
-	rw.body = append(rw.body, p...)
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	rw.body, rw.truncated = capture(rw.body, p, rw.maxBodySize, rw.truncated)
 	return rw.ResponseWriter.Write(p)
 }
 
 func (rw *ResponseWriter) WriteHeader(s int) {
+	if rw.wroteHeader {
+		return
+	}
+
+	rw.wroteHeader = true
 	rw.status = s
+
+	header := rw.ResponseWriter.Header()
+	for key, vals := range rw.header {
+		if key == "Vary" {
+			header[key] = append(header[key], vals...)
+			continue
+		}
+
+		header[key] = vals
+	}
+
+	if rw.beforeWriteHeader != nil {
+		rw.beforeWriteHeader(s)
+	}
+
 	rw.ResponseWriter.WriteHeader(s)
 }
+
+func (rw *ResponseWriter) Flush() {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// bufferWriter captures a whole response without sending anything to the client.
+type bufferWriter struct {
+	header      http.Header
+	status      int
+	body        []byte
+	maxBodySize int
+	truncated   bool
+}
+
+func newBufferWriter() *bufferWriter {
+	return &bufferWriter{header: make(http.Header)}
+}
+
+func (bw *bufferWriter) Header() http.Header {
+	return bw.header
+}
+
+func (bw *bufferWriter) Write(p []byte) (int, error) {
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+
+	bw.body, bw.truncated = capture(bw.body, p, bw.maxBodySize, bw.truncated)
+	return len(p), nil
+}
+
+func (bw *bufferWriter) WriteHeader(s int) {
+	if bw.status == 0 {
+		bw.status = s
+	}
+}
+
+// revalidationWriter holds back the upstream answer to a revalidation until its status is
+// known. A status for which buffered returns true is captured to be handled by the cache,
+// any other response is streamed to the client, unless the client was answered otherwise.
+type revalidationWriter struct {
+	*bufferWriter
+	client   *ResponseWriter
+	buffered func(status int) bool
+	// withBody is false for a HEAD request: the body is captured but not sent.
+	withBody bool
+
+	mu        sync.Mutex
+	decided   bool
+	streaming bool
+	abandoned bool
+}
+
+func newRevalidationWriter(client *ResponseWriter, withBody bool, buffered func(status int) bool) *revalidationWriter {
+	w := &revalidationWriter{bufferWriter: newBufferWriter(), client: client, buffered: buffered, withBody: withBody}
+	// Streamed responses are sent with the headers set by the upstream so far.
+	client.header = w.header
+
+	return w
+}
+
+func (w *revalidationWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if !w.streaming {
+		return w.bufferWriter.Write(p)
+	}
+
+	if !w.withBody {
+		w.client.body, w.client.truncated = capture(w.client.body, p, w.client.maxBodySize, w.client.truncated)
+		return len(p), nil
+	}
+
+	return w.client.Write(p)
+}
+
+func (w *revalidationWriter) WriteHeader(s int) {
+	w.mu.Lock()
+	if w.decided {
+		w.mu.Unlock()
+		return
+	}
+	w.decided = true
+	w.streaming = !w.abandoned && !w.buffered(s)
+	w.mu.Unlock()
+
+	w.bufferWriter.WriteHeader(s)
+	if w.streaming {
+		w.client.WriteHeader(s)
+	}
+}
+
+func (w *revalidationWriter) Flush() {
+	if !w.decided {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.streaming {
+		w.client.Flush()
+	}
+}
+
+// abandon keeps the response from being streamed, the client being answered otherwise.
+// It returns false when the response is already being streamed.
+func (w *revalidationWriter) abandon() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.streaming {
+		return false
+	}
+	w.abandoned = true
+
+	return true
+}
+
+// capture appends p to body unless it would exceed maxBodySize (0 meaning no limit), in
+// which case the captured body is dropped and truncated is reported.
+func capture(body, p []byte, maxBodySize int, truncated bool) ([]byte, bool) {
+	if truncated {
+		return nil, true
+	}
+
+	if maxBodySize > 0 && len(body)+len(p) > maxBodySize {
+		return nil, true
+	}
+
+	return append(body, p...), false
+}